@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/marcbran/yokai/internal/client"
+	"github.com/marcbran/yokai/internal/serve"
 	"github.com/spf13/cobra"
 )
 
@@ -25,7 +26,7 @@ var getCmd = &cobra.Command{
 			return err
 		}
 
-		config, err := loadConfig(configPath)
+		config, err := serve.LoadConfig(configPath)
 		if err != nil {
 			return err
 		}
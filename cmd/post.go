@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/marcbran/yokai/internal/client"
+	"github.com/marcbran/yokai/internal/serve"
 	"github.com/spf13/cobra"
 )
 
@@ -32,7 +33,7 @@ var postCmd = &cobra.Command{
 			return err
 		}
 
-		config, err := loadConfig(configPath)
+		config, err := serve.LoadConfig(configPath)
 		if err != nil {
 			return err
 		}
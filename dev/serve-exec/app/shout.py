@@ -0,0 +1,9 @@
+import json
+import sys
+
+# Reads sink messages as JSON lines and publishes them back to the source
+# with the payload upper-cased.
+for line in sys.stdin:
+    message = json.loads(line)
+    payload = json.loads(message["payload"])
+    print(json.dumps({"topic": "shout/out", "payload": {"text": payload["text"].upper()}}), flush=True)
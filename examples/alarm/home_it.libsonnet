@@ -0,0 +1,27 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'at',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{}' },
+      ],
+      outputs: [
+        { topic: 'yokai/schedule', payload: '{"at":"2000-01-01T06:30:00Z","id":"alarm","message":{"ring":true},"topic":"yokai/test/wake"}' },
+        { topic: 'yokai/test/output', payload: '{"ring":true}' },
+      ],
+    },
+    {
+      name: 'at by the test clock',
+      // The alarm is due only by the clock of the test, not by the wall clock.
+      now: '2100-01-01T07:00:00Z',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{"at":"2100-01-01T06:30:00Z"}' },
+      ],
+      outputs: [
+        { topic: 'yokai/schedule', payload: '{"at":"2100-01-01T06:30:00Z","id":"alarm","message":{"ring":true},"topic":"yokai/test/wake"}' },
+        { topic: 'yokai/test/output', payload: '{"ring":true}' },
+      ],
+    },
+  ],
+}
@@ -0,0 +1,25 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'unset',
+      inputs: [
+        { topic: 'yokai/test/input-b', payload: '{}' },
+      ],
+      outputs: [
+        { topic: 'yokai/kv/get', payload: '{"key":"away","topic":"yokai/test/heater/away"}' },
+        { topic: 'yokai/test/output', payload: '{"heating":true}' },
+      ],
+    },
+    {
+      name: 'away',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{"away":true}' },
+      ],
+      outputs: [
+        { topic: 'yokai/kv/set', payload: '{"key":"away","value":true}' },
+        { topic: 'yokai/test/output', payload: '{"heating":false}' },
+      ],
+    },
+  ],
+}
@@ -0,0 +1,15 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'arrive',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{"action":"unlock"}' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/presence', payload: '{"home":true}' },
+        { topic: 'yokai/test/output', payload: '{"state":"ON"}' },
+      ],
+    },
+  ],
+}
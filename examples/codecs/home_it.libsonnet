@@ -0,0 +1,42 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'text',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: 'ON' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: 'OFF' },
+      ],
+    },
+    {
+      name: 'base64',
+      inputs: [
+        { topic: 'yokai/test/input-b', payload: 'hi' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: '{"frame":"aGk="}' },
+        { topic: 'yokai/test/output', payload: 'hi' },
+      ],
+    },
+    {
+      name: 'form',
+      inputs: [
+        { topic: 'yokai/test/input-c', payload: 'room=kitchen&level=3' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: 'level=3&room=kitchen&seen=true' },
+      ],
+    },
+    {
+      name: 'invalid json',
+      inputs: [
+        { topic: 'yokai/test/input-d', payload: '21 C' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: '{"error":"failed to decode payload of topic yokai/test/input-d for app config/switch: payload is not valid JSON","payload":"21 C","topic":"yokai/test/input-d"}' },
+      ],
+    },
+  ],
+}
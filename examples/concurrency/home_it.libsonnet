@@ -0,0 +1,18 @@
+local count = 24;
+
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'interleaved',
+      inputs: [
+        { topic: if i % 2 == 0 then 'yokai/test/input-a' else 'yokai/test/input-b', payload: '{}' }
+        for i in std.range(1, count)
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: std.manifestJsonMinified({ value: i }) }
+        for i in std.range(1, count)
+      ],
+    },
+  ],
+}
@@ -0,0 +1,14 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'update error',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{"temperature":"warm"}' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: '{"app":"config/thermostat","error":"RUNTIME ERROR: invalid temperature","stage":"update","topic":"yokai/test/input-a"}' },
+      ],
+    },
+  ],
+}
@@ -0,0 +1,20 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'debounce',
+      // A second OFF from the replaced delay would arrive before settled.
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{}' },
+        { topic: 'yokai/test/input-a', payload: '{}' },
+      ],
+      outputs: [
+        { topic: 'yokai/delay', payload: '{"id":"light-off","message":{"state":"OFF"},"milliseconds":100,"topic":"yokai/test/off"}' },
+        { topic: 'yokai/delay', payload: '{"id":"light-off","message":{"state":"OFF"},"milliseconds":100,"topic":"yokai/test/off"}' },
+        { topic: 'yokai/delay', payload: '{"id":"settled","message":{"settled":true},"milliseconds":300,"topic":"yokai/test/settled"}' },
+        { topic: 'yokai/test/output', payload: '{"state":"OFF"}' },
+        { topic: 'yokai/test/output', payload: '{"settled":true}' },
+      ],
+    },
+  ],
+}
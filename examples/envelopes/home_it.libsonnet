@@ -0,0 +1,16 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'ordered',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{}' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: '{"open":true}' },
+        { topic: 'yokai/test/garage/set', payload: '{"open":true}', qos: 1 },
+        { topic: 'yokai/test/garage/state', payload: '"open"', retain: true },
+      ],
+    },
+  ],
+}
@@ -0,0 +1,24 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'window closed',
+      inputs: [
+        { topic: 'yokai/test/input-b', payload: '{"on":true}' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: '{"state":"ON"}' },
+      ],
+    },
+    {
+      name: 'window opened',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{"contact":false}' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/window', payload: '{}' },
+        { topic: 'yokai/test/output', payload: '{"state":"OFF"}' },
+      ],
+    },
+  ],
+}
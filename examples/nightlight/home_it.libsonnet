@@ -0,0 +1,25 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'night',
+      now: '2024-01-01T22:30:00Z',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{"device":"hallway-sensor"}' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: '{"at":"2024-01-01T22:30:00Z","brightness":30,"room":"hallway"}' },
+      ],
+    },
+    {
+      name: 'day',
+      now: '2024-01-01T12:00:00Z',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{"device":"kitchen-sensor"}' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: '{"at":"2024-01-01T12:00:00Z","brightness":255,"room":"kitchen"}' },
+      ],
+    },
+  ],
+}
@@ -0,0 +1,25 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'max hops',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{"n":1}' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/ping', payload: '{"n":1}' },
+        { topic: 'yokai/test/pong', payload: '{"n":1}' },
+        { topic: 'yokai/test/ping', payload: '{"n":1}' },
+        { topic: 'yokai/test/pong', payload: '{"n":1}' },
+        { topic: 'yokai/test/ping', payload: '{"n":1}' },
+        { topic: 'yokai/test/pong', payload: '{"n":1}' },
+        { topic: 'yokai/test/ping', payload: '{"n":1}' },
+        { topic: 'yokai/test/pong', payload: '{"n":1}' },
+        { topic: 'yokai/test/ping', payload: '{"n":1}' },
+        { topic: 'yokai/test/pong', payload: '{"n":1}' },
+        { topic: 'yokai/test/ping', payload: '{"n":1}' },
+        { topic: 'yokai/test/output', payload: '{"hops":10,"topic":"yokai/test/ping"}' },
+      ],
+    },
+  ],
+}
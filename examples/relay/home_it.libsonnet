@@ -0,0 +1,24 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'host not allowed',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{"on":true}' },
+      ],
+      outputs: [
+        { topic: 'yokai/http', payload: '{"body":{"id":0,"on":true},"id":"relay-0","method":"POST","topic":"yokai/test/relay/reply","url":"http://shelly.local/rpc/Switch.Set"}' },
+        { topic: 'yokai/test/output', payload: '{"error":"host shelly.local is not allowed","ok":false}' },
+      ],
+    },
+    {
+      name: 'reply',
+      inputs: [
+        { topic: 'yokai/test/relay/reply', payload: '{"id":"relay-0","status":200,"headers":{},"body":{"was_on":false}}' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: '{"ok":true}' },
+      ],
+    },
+  ],
+}
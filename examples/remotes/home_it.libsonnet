@@ -0,0 +1,18 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'wildcard',
+      inputs: [
+        { topic: 'zigbee2mqtt/kitchen/action', payload: '"on"' },
+        { topic: 'zigbee2mqtt/hallway/action', payload: '"off"' },
+        { topic: 'zigbee2mqtt/kitchen/action', payload: '"off"' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: '{"action":"on","presses":1,"remote":"kitchen"}' },
+        { topic: 'yokai/test/output', payload: '{"action":"off","presses":1,"remote":"hallway"}' },
+        { topic: 'yokai/test/output', payload: '{"action":"off","presses":2,"remote":"kitchen"}' },
+      ],
+    },
+  ],
+}
@@ -0,0 +1,55 @@
+// This program embeds yokai and adds a Go-native command on shout/upper,
+// which the shout app in config.jsonnet uses to upper-case its input.
+//
+//	go run ./examples/sdk
+//	curl -X POST localhost:8000/shout/say -d '{"text":"hello"}'
+//	curl localhost:8000/shout
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/marcbran/yokai/pkg/yokai"
+	log "github.com/sirupsen/logrus"
+)
+
+type upper struct {
+	Text  string      `json:"text"`
+	Reply yokai.Topic `json:"reply"`
+}
+
+func upperCommand(ctx context.Context, topic yokai.Topic, payload yokai.Payload) (map[yokai.Topic]yokai.Payload, error) {
+	var req upper
+	err := json.Unmarshal([]byte(payload), &req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := json.Marshal(map[string]string{"text": strings.ToUpper(req.Text)})
+	if err != nil {
+		return nil, err
+	}
+	return map[yokai.Topic]yokai.Payload{
+		req.Reply: string(res),
+	}, nil
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	configPath := flag.String("config", "examples/sdk", "directory containing config.yaml")
+	flag.Parse()
+
+	err := yokai.Serve(ctx,
+		yokai.WithConfigPath(*configPath),
+		yokai.WithCommand("shout/upper", yokai.CommandFunc(upperCommand)),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,12 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'request state',
+      inputs: [],
+      outputs: [
+        { topic: 'zigbee2mqtt/lamp/get', payload: '{"state":""}' },
+      ],
+    },
+  ],
+}
@@ -0,0 +1,24 @@
+{
+  config: import './home.jsonnet',
+  tests: [
+    {
+      name: 'command not allowed',
+      inputs: [
+        { topic: 'yokai/test/input-a', payload: '{"mac":"00:11:22:33:44:55"}' },
+      ],
+      outputs: [
+        { topic: 'yokai/exec', payload: '{"id":"wake-nas","name":"wakeonlan","params":{"mac":"00:11:22:33:44:55"},"topic":"yokai/test/nas/reply"}' },
+        { topic: 'yokai/test/output', payload: '{"awake":false,"error":"command \\"wakeonlan\\" is not allowed"}' },
+      ],
+    },
+    {
+      name: 'reply',
+      inputs: [
+        { topic: 'yokai/test/nas/reply', payload: '{"id":"wake-nas","name":"wakeonlan","exitCode":0,"stdout":"","stderr":""}' },
+      ],
+      outputs: [
+        { topic: 'yokai/test/output', payload: '{"awake":true}' },
+      ],
+    },
+  ],
+}
@@ -47,6 +47,7 @@ func (c *Client) Post(ctx context.Context, topic, payload string) error {
 	}
 
 	req.Header.Set("Content-Type", "text/plain")
+	c.authorize(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -85,6 +86,7 @@ func (c *Client) Get(ctx context.Context, view string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
+	c.authorize(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -108,3 +110,15 @@ func (c *Client) Get(ctx context.Context, view string) (string, error) {
 
 	return string(body), nil
 }
+
+// authorize adds the configured credentials, preferring a token over basic
+// auth.
+func (c *Client) authorize(req *http.Request) {
+	credentials := c.config.Credentials
+	switch {
+	case credentials.Token != "":
+		req.Header.Set("Authorization", "Bearer "+credentials.Token)
+	case credentials.Username != "":
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+}
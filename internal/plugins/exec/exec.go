@@ -0,0 +1,302 @@
+package exec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	osexec "os/exec"
+	"syscall"
+	"time"
+
+	"github.com/marcbran/yokai/internal/run"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	DefaultMinBackoff  = time.Second
+	DefaultMaxBackoff  = time.Minute
+	DefaultStopTimeout = 5 * time.Second
+)
+
+type Config struct {
+	Enabled   bool            `mapstructure:"enabled"`
+	Processes []ProcessConfig `mapstructure:"processes"`
+}
+
+type ProcessConfig struct {
+	Name        string        `mapstructure:"name"`
+	Command     string        `mapstructure:"command"`
+	Args        []string      `mapstructure:"args"`
+	Dir         string        `mapstructure:"dir"`
+	Env         []string      `mapstructure:"env"`
+	Source      []run.Topic   `mapstructure:"source"`
+	Sink        []run.Topic   `mapstructure:"sink"`
+	MinBackoff  time.Duration `mapstructure:"min_backoff"`
+	MaxBackoff  time.Duration `mapstructure:"max_backoff"`
+	StopTimeout time.Duration `mapstructure:"stop_timeout"`
+}
+
+// Line is one message of the stdio protocol. Lines written to a child carry
+// the broker the message was seen on and the raw payload as a JSON string.
+// Lines read from a child are published to the source unless they name the
+// sink; a string payload is published as is, any other JSON value as JSON.
+type Line struct {
+	Broker  string          `json:"broker,omitempty"`
+	Topic   run.Topic       `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type ExecPlugin struct {
+	config Config
+}
+
+func NewPlugin(config Config) *ExecPlugin {
+	return &ExecPlugin{
+		config: config,
+	}
+}
+
+func (e *ExecPlugin) Start(ctx context.Context, g *errgroup.Group, registry run.Registry, source run.Broker, view run.Broker, sink run.Broker) {
+	if !e.config.Enabled {
+		log.Info("Exec plugin is disabled")
+		return
+	}
+
+	for _, config := range e.config.Processes {
+		if config.MinBackoff <= 0 {
+			config.MinBackoff = DefaultMinBackoff
+		}
+		if config.MaxBackoff <= 0 {
+			config.MaxBackoff = DefaultMaxBackoff
+		}
+		if config.StopTimeout <= 0 {
+			config.StopTimeout = DefaultStopTimeout
+		}
+		if config.Name == "" {
+			config.Name = config.Command
+		}
+
+		var inputs []input
+		if len(config.Source) > 0 {
+			ch, unsubscribe := source.SubscribeAll(run.WithPolicy(run.PolicyUnbounded))
+			inputs = append(inputs, input{broker: "source", filters: config.Source, ch: ch, unsubscribe: unsubscribe})
+		}
+		if len(config.Sink) > 0 {
+			ch, unsubscribe := sink.SubscribeAll(run.WithPolicy(run.PolicyUnbounded))
+			inputs = append(inputs, input{broker: "sink", filters: config.Sink, ch: ch, unsubscribe: unsubscribe})
+		}
+
+		g.Go(func() error {
+			execCtx, execCancel := context.WithCancel(ctx)
+			defer execCancel()
+
+			err := runProcess(execCtx, config, inputs, source, sink)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				return err
+			}
+			return nil
+		})
+	}
+}
+
+type input struct {
+	broker      string
+	filters     []run.Topic
+	ch          <-chan run.TopicPayload
+	unsubscribe run.Unsubscribe
+}
+
+// runProcess keeps the child running until ctx is cancelled, restarting it
+// with exponential backoff whenever it exits.
+func runProcess(
+	ctx context.Context,
+	config ProcessConfig,
+	inputs []input,
+	source run.Broker,
+	sink run.Broker,
+) error {
+	for _, input := range inputs {
+		defer input.unsubscribe()
+	}
+
+	g, gCtx := errgroup.WithContext(ctx)
+
+	lines := make(chan Line)
+	for _, input := range inputs {
+		g.Go(func() error {
+			return forwardInput(gCtx, input, lines)
+		})
+	}
+
+	g.Go(func() error {
+		backoff := config.MinBackoff
+		for {
+			started := time.Now()
+			err := runChild(gCtx, config, lines, source, sink)
+			if gCtx.Err() != nil {
+				return gCtx.Err()
+			}
+			if time.Since(started) > config.MaxBackoff {
+				backoff = config.MinBackoff
+			}
+			log.WithError(err).
+				WithField("name", config.Name).
+				WithField("backoff", backoff.String()).
+				Error("process exited, restarting")
+
+			select {
+			case <-gCtx.Done():
+				return gCtx.Err()
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, config.MaxBackoff)
+		}
+	})
+
+	return g.Wait()
+}
+
+func forwardInput(ctx context.Context, input input, lines chan<- Line) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case tp, ok := <-input.ch:
+			if !ok {
+				return nil
+			}
+			if !run.MatchesAny(input.filters, tp.Topic) {
+				continue
+			}
+			payload, err := json.Marshal(tp.Payload)
+			if err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case lines <- Line{Broker: input.broker, Topic: tp.Topic, Payload: payload}:
+			}
+		}
+	}
+}
+
+func runChild(
+	ctx context.Context,
+	config ProcessConfig,
+	lines <-chan Line,
+	source run.Broker,
+	sink run.Broker,
+) error {
+	cmd := osexec.CommandContext(ctx, config.Command, config.Args...)
+	cmd.Dir = config.Dir
+	cmd.Env = append(os.Environ(), config.Env...)
+	cmd.Stderr = os.Stderr
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = config.StopTimeout
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	log.WithField("name", config.Name).
+		WithField("pid", cmd.Process.Pid).
+		Info("started process")
+
+	childCtx, childCancel := context.WithCancel(ctx)
+	defer childCancel()
+
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		writeLines(childCtx, config, stdin, lines)
+	}()
+
+	readLines(config, stdout, source, sink)
+
+	err = cmd.Wait()
+	childCancel()
+	<-writeDone
+	return err
+}
+
+func writeLines(ctx context.Context, config ProcessConfig, stdin io.WriteCloser, lines <-chan Line) {
+	defer func() {
+		_ = stdin.Close()
+	}()
+
+	encoder := json.NewEncoder(stdin)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line := <-lines:
+			err := encoder.Encode(line)
+			if err != nil {
+				log.WithError(err).
+					WithField("name", config.Name).
+					WithField("topic", line.Topic).
+					Error("failed to write line to process")
+				return
+			}
+		}
+	}
+}
+
+func readLines(config ProcessConfig, stdout io.Reader, source run.Broker, sink run.Broker) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var line Line
+		err := json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil || line.Topic == "" {
+			log.WithError(err).
+				WithField("name", config.Name).
+				WithField("line", scanner.Text()).
+				Error("ignoring invalid line from process")
+			continue
+		}
+		payload := string(line.Payload)
+		var s string
+		if json.Unmarshal(line.Payload, &s) == nil {
+			payload = s
+		}
+
+		log.WithField("name", config.Name).
+			WithField("topic", line.Topic).
+			WithField("payload", payload).
+			Info("received message from process")
+
+		switch line.Broker {
+		case "", "source":
+			source.Publish(line.Topic, payload)
+		case "sink":
+			sink.Publish(line.Topic, payload)
+		default:
+			log.WithField("name", config.Name).
+				WithField("broker", line.Broker).
+				Error("ignoring line for unknown broker")
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		log.WithError(err).
+			WithField("name", config.Name).
+			Error("failed to read from process")
+	}
+}
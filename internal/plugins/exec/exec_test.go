@@ -0,0 +1,177 @@
+package exec
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marcbran/yokai/internal/run"
+	"golang.org/x/sync/errgroup"
+)
+
+func startProcess(t *testing.T, config ProcessConfig, source run.Broker, sink run.Broker) (context.CancelFunc, *errgroup.Group) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	g, gCtx := errgroup.WithContext(ctx)
+	NewPlugin(Config{Enabled: true, Processes: []ProcessConfig{config}}).
+		Start(gCtx, g, run.Registry{}, source, nil, sink)
+	t.Cleanup(func() {
+		cancel()
+		_ = g.Wait()
+	})
+	return cancel, g
+}
+
+func receive(t *testing.T, ch <-chan run.TopicPayload) run.TopicPayload {
+	t.Helper()
+	select {
+	case tp := <-ch:
+		return tp
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a message")
+		return run.TopicPayload{}
+	}
+}
+
+func TestProcessRoutesLines(t *testing.T) {
+	source := run.NewBroker("source", run.BrokerConfig{})
+	sink := run.NewBroker("sink", run.BrokerConfig{})
+	sourceCh, _ := source.Subscribe("lights/#")
+	sinkCh, _ := sink.Subscribe("zigbee/#")
+
+	startProcess(t, ProcessConfig{
+		Command: "sh",
+		Args: []string{"-c", `
+echo '{"topic":"lights/kitchen","payload":"on"}'
+echo 'not json'
+echo '{"payload":"no topic"}'
+echo '{"broker":"other","topic":"lights/ignored","payload":"on"}'
+echo '{"broker":"sink","topic":"zigbee/kitchen/set","payload":{"state":"ON"}}'
+echo '{"broker":"source","topic":"lights/done","payload":1}'
+exec sleep 30
+`},
+	}, source, sink)
+
+	tp := receive(t, sourceCh)
+	if tp.Topic != "lights/kitchen" || tp.Payload != "on" {
+		t.Errorf("expected a string payload to be published as is, got %v", tp)
+	}
+	tp = receive(t, sourceCh)
+	if tp.Topic != "lights/done" || tp.Payload != "1" {
+		t.Errorf("expected invalid lines and unknown brokers to be skipped, got %v", tp)
+	}
+	tp = receive(t, sinkCh)
+	if tp.Topic != "zigbee/kitchen/set" || tp.Payload != `{"state":"ON"}` {
+		t.Errorf("expected a JSON payload to be published to the sink, got %v", tp)
+	}
+}
+
+func TestProcessForwardsMatchingInput(t *testing.T) {
+	source := run.NewBroker("source", run.BrokerConfig{})
+	sink := run.NewBroker("sink", run.BrokerConfig{})
+	outCh, _ := source.Subscribe("out/#")
+
+	// The child echoes every line back to the source under out/.
+	startProcess(t, ProcessConfig{
+		Command: "sh",
+		Args: []string{"-c", `
+while read -r line; do
+  echo "$line" | sed -e 's/"broker":"sink"/"broker":"source"/' -e 's/"topic":"[a-z]*\//"topic":"out\//'
+done
+`},
+		Source: []run.Topic{"in/#"},
+		Sink:   []run.Topic{"zigbee/#"},
+	}, source, sink)
+
+	source.Publish("ignored/x", "no")
+	source.Publish("in/a", "hello")
+	sink.Publish("zigbee/b", `{"state":"ON"}`)
+
+	received := make(map[run.Topic]run.Payload)
+	for range 2 {
+		tp := receive(t, outCh)
+		received[tp.Topic] = tp.Payload
+	}
+	if len(received) != 2 || received["out/a"] != "hello" || received["out/b"] != `{"state":"ON"}` {
+		t.Errorf("expected only the matching messages to be forwarded, got %v", received)
+	}
+}
+
+func TestProcessRestartsWithBackoff(t *testing.T) {
+	source := run.NewBroker("source", run.BrokerConfig{})
+	ch, _ := source.Subscribe("started")
+
+	startProcess(t, ProcessConfig{
+		Command:    "sh",
+		Args:       []string{"-c", `echo '{"topic":"started","payload":"x"}'; exit 1`},
+		MinBackoff: 25 * time.Millisecond,
+		MaxBackoff: 100 * time.Millisecond,
+	}, source, run.NewBroker("sink", run.BrokerConfig{}))
+
+	var starts []time.Time
+	for range 5 {
+		receive(t, ch)
+		starts = append(starts, time.Now())
+	}
+
+	expected := []time.Duration{25, 50, 100, 100}
+	for i, backoff := range expected {
+		backoff *= time.Millisecond
+		gap := starts[i+1].Sub(starts[i])
+		if gap < backoff-10*time.Millisecond {
+			t.Errorf("expected restart %d to wait at least %s, waited %s", i+1, backoff, gap)
+		}
+	}
+}
+
+func TestProcessStopsOnCancel(t *testing.T) {
+	source := run.NewBroker("source", run.BrokerConfig{})
+	ch, _ := source.Subscribe("#")
+
+	cancel, g := startProcess(t, ProcessConfig{
+		Command: "sh",
+		Args: []string{"-c", `
+trap 'echo "{\"topic\":\"stopped\",\"payload\":\"x\"}"; exit 0' TERM
+echo '{"topic":"started","payload":"x"}'
+while true; do sleep 0.01; done
+`},
+	}, source, run.NewBroker("sink", run.BrokerConfig{}))
+
+	if tp := receive(t, ch); tp.Topic != "started" {
+		t.Fatalf("expected the process to start, got %v", tp)
+	}
+	cancel()
+	if tp := receive(t, ch); tp.Topic != "stopped" {
+		t.Errorf("expected the process to be terminated gracefully, got %v", tp)
+	}
+	err := g.Wait()
+	if err != nil {
+		t.Errorf("expected a clean stop, got %v", err)
+	}
+}
+
+func TestProcessKilledAfterStopTimeout(t *testing.T) {
+	source := run.NewBroker("source", run.BrokerConfig{})
+	ch, _ := source.Subscribe("started")
+
+	cancel, g := startProcess(t, ProcessConfig{
+		Command: "sh",
+		Args: []string{"-c", `
+trap '' TERM
+echo '{"topic":"started","payload":"x"}'
+while true; do sleep 0.01; done
+`},
+		StopTimeout: 100 * time.Millisecond,
+	}, source, run.NewBroker("sink", run.BrokerConfig{}))
+
+	receive(t, ch)
+	start := time.Now()
+	cancel()
+	err := g.Wait()
+	if err != nil {
+		t.Errorf("expected a clean stop, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the process to be killed after the stop timeout, it took %s", elapsed)
+	}
+}
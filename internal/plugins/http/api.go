@@ -0,0 +1,260 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/marcbran/yokai/internal/run"
+	log "github.com/sirupsen/logrus"
+)
+
+const maxPublishSize = 1 << 20
+
+type apiApp struct {
+	Key           run.Key     `json:"key"`
+	Subscriptions []run.Topic `json:"subscriptions"`
+	Outputs       []run.Topic `json:"outputs"`
+}
+
+type apiView struct {
+	Key  run.Key `json:"key"`
+	View string  `json:"view"`
+}
+
+type apiModel struct {
+	Key   run.Key `json:"key"`
+	Model any     `json:"model"`
+}
+
+// apiPublish is the body of a publish. A string payload is published as
+// is, any other JSON value as JSON.
+type apiPublish struct {
+	Topic   run.Topic       `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// apiStats lists the subscriptions of each broker with the messages they
+// have queued and dropped.
+type apiStats struct {
+	Source []run.SubscriptionStats `json:"source"`
+	View   []run.SubscriptionStats `json:"view"`
+	Sink   []run.SubscriptionStats `json:"sink"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func registerApi(mux *http.ServeMux, registry run.Registry, source run.Broker, view run.Broker, sink run.Broker) {
+	mux.HandleFunc("/api/apps", handleApiApps(registry))
+	mux.HandleFunc("/api/apps/", handleApiApp(registry))
+	mux.HandleFunc("/api/publish", handleApiPublish(source))
+	mux.HandleFunc("/api/stats", handleApiStats(source, view, sink))
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		writeApiError(w, http.StatusNotFound, "not found")
+	})
+}
+
+func handleApiApps(registry run.Registry) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		subscriptions := make(map[run.Key][]run.Topic)
+		for topic, models := range registry.TopicToModels {
+			for _, model := range models {
+				subscriptions[model.Key()] = append(subscriptions[model.Key()], topic)
+			}
+		}
+
+		p := principalFrom(r)
+		apps := make([]apiApp, 0, len(registry.KeyToModel))
+		for key, model := range registry.KeyToModel {
+			if !p.allowsKey(key) {
+				continue
+			}
+			app := apiApp{
+				Key:           key,
+				Subscriptions: subscriptions[key],
+				Outputs:       []run.Topic{},
+			}
+			sort.Strings(app.Subscriptions)
+			if described, ok := model.(run.DescribedModel); ok {
+				app.Subscriptions = described.Subscriptions()
+				app.Outputs = described.Outputs()
+			}
+			if app.Subscriptions == nil {
+				app.Subscriptions = []run.Topic{}
+			}
+			if app.Outputs == nil {
+				app.Outputs = []run.Topic{}
+			}
+			apps = append(apps, app)
+		}
+		sort.Slice(apps, func(i, j int) bool {
+			return apps[i].Key < apps[j].Key
+		})
+
+		writeApiJson(w, http.StatusOK, apps)
+	}
+}
+
+// handleApiApp serves /api/apps/<key>/model and /api/apps/<key>/view. Keys
+// may contain slashes, so the path is split at the last one.
+func handleApiApp(registry run.Registry) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/apps/")
+		i := strings.LastIndex(path, "/")
+		if i < 0 {
+			writeApiError(w, http.StatusNotFound, "not found")
+			return
+		}
+		key, resource := path[:i], path[i+1:]
+		if resource != "model" && resource != "view" {
+			writeApiError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if r.Method != http.MethodGet {
+			writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		model, ok := registry.KeyToModel[key]
+		if !ok {
+			writeApiError(w, http.StatusNotFound, fmt.Sprintf("app %s not found", key))
+			return
+		}
+		if !principalFrom(r).allowsKey(key) {
+			writeApiError(w, http.StatusForbidden, fmt.Sprintf("access to app %s is not allowed", key))
+			return
+		}
+
+		switch resource {
+		case "model":
+			described, ok := model.(run.DescribedModel)
+			if !ok {
+				writeApiError(w, http.StatusNotImplemented, fmt.Sprintf("app %s does not expose its model", key))
+				return
+			}
+			state, err := described.State(r.Context())
+			if err != nil {
+				log.WithError(err).
+					WithField("key", key).
+					Error("failed to read model")
+				writeApiError(w, http.StatusInternalServerError, "failed to read model")
+				return
+			}
+			writeApiJson(w, http.StatusOK, apiModel{Key: key, Model: state})
+		case "view":
+			view, err := model.View(r.Context())
+			if err != nil {
+				log.WithError(err).
+					WithField("key", key).
+					Error("failed to handle view")
+				writeApiError(w, http.StatusInternalServerError, "failed to render view")
+				return
+			}
+			writeApiJson(w, http.StatusOK, apiView{Key: key, View: view})
+		}
+	}
+}
+
+func handleApiPublish(source run.Broker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var publish apiPublish
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPublishSize))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&publish)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeApiError(w, http.StatusRequestEntityTooLarge, "request body too large")
+				return
+			}
+			if errors.Is(err, io.EOF) {
+				writeApiError(w, http.StatusBadRequest, "request body is required")
+				return
+			}
+			writeApiError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %s", err))
+			return
+		}
+		if publish.Topic == "" {
+			writeApiError(w, http.StatusBadRequest, "topic is required")
+			return
+		}
+		if run.IsWildcard(publish.Topic) {
+			writeApiError(w, http.StatusBadRequest, "topic must not contain wildcards")
+			return
+		}
+		if !principalFrom(r).allowsTopic(publish.Topic) {
+			writeApiError(w, http.StatusForbidden, fmt.Sprintf("publishing to %s is not allowed", publish.Topic))
+			return
+		}
+
+		payload := string(publish.Payload)
+		var s string
+		if json.Unmarshal(publish.Payload, &s) == nil {
+			payload = s
+		}
+		source.Publish(publish.Topic, payload)
+
+		writeApiJson(w, http.StatusAccepted, apiPublish{Topic: publish.Topic, Payload: publish.Payload})
+	}
+}
+
+// handleApiStats serves the subscriptions a principal may see: those of the
+// view broker by app key and the others by topic filter.
+func handleApiStats(source run.Broker, view run.Broker, sink run.Broker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		p := principalFrom(r)
+		writeApiJson(w, http.StatusOK, apiStats{
+			Source: brokerStats(source, p.allowsTopic),
+			View:   brokerStats(view, p.allowsKey),
+			Sink:   brokerStats(sink, p.allowsTopic),
+		})
+	}
+}
+
+func brokerStats(broker run.Broker, allows func(string) bool) []run.SubscriptionStats {
+	res := []run.SubscriptionStats{}
+	statsBroker, ok := broker.(run.StatsBroker)
+	if !ok {
+		return res
+	}
+	for _, stats := range statsBroker.Stats() {
+		if allows(stats.Filter) {
+			res = append(res, stats)
+		}
+	}
+	return res
+}
+
+func writeApiJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.WithError(err).
+			Error("failed to write api response")
+	}
+}
+
+func writeApiError(w http.ResponseWriter, status int, message string) {
+	writeApiJson(w, status, apiError{Error: message})
+}
@@ -0,0 +1,270 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcbran/yokai/internal/run"
+)
+
+func TestApiStats(t *testing.T) {
+	source := run.NewBroker("source", run.BrokerConfig{BufferSize: 1})
+	view := run.NewBroker("view", run.BrokerConfig{})
+	sink := run.NewBroker("sink", run.BrokerConfig{})
+	_, unsubscribeLights := source.Subscribe("lights/+", run.WithPolicy(run.PolicyDropNewest))
+	defer unsubscribeLights()
+	_, unsubscribeHeater := source.Subscribe("heater/+")
+	defer unsubscribeHeater()
+	_, unsubscribeView := view.Subscribe("lights")
+	defer unsubscribeView()
+	source.Publish("lights/kitchen", "1")
+	source.Publish("lights/kitchen", "2")
+
+	mux := http.NewServeMux()
+	registerApi(mux, run.NewRegistry(), run.NewInterceptedBroker(source), view, sink)
+	server := httptest.NewServer(newAuthenticator(AuthConfig{
+		Enabled: true,
+		Tokens: []TokenConfig{
+			{Name: "lights", Token: "lights-token", Keys: []string{"lights"}, Topics: []string{"lights"}},
+		},
+	}).middleware(mux))
+	defer server.Close()
+
+	req := mustRequest(t, http.MethodGet, server.URL+"/api/stats")
+	req.Header.Set("Authorization", "Bearer lights-token")
+	resp := doRequest(t, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	var stats apiStats
+	err := json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := apiStats{
+		Source: []run.SubscriptionStats{
+			{Filter: "lights/+", Policy: run.PolicyDropNewest, Queued: 1, Dropped: 1},
+		},
+		View: []run.SubscriptionStats{
+			{Filter: "lights", Policy: run.PolicyDropNewest, Queued: 0, Dropped: 0},
+		},
+		Sink: []run.SubscriptionStats{},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %+v, got %+v", expected, stats)
+	}
+
+	req = mustRequest(t, http.MethodPost, server.URL+"/api/stats")
+	req.Header.Set("Authorization", "Bearer lights-token")
+	if resp := doRequest(t, req); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", resp.StatusCode)
+	}
+}
+
+type testModel struct {
+	key  run.Key
+	view string
+}
+
+func (m testModel) Key() string { return m.key }
+func (m testModel) Update(ctx context.Context, filter run.Topic, topic run.Topic, payload run.Payload) ([]run.TopicPayload, error) {
+	return nil, nil
+}
+func (m testModel) View(ctx context.Context) (string, error) { return m.view, nil }
+
+type testDescribedModel struct {
+	testModel
+	state any
+}
+
+func (m testDescribedModel) Subscriptions() []run.Topic { return []run.Topic{"lights/+"} }
+func (m testDescribedModel) Outputs() []run.Topic       { return []run.Topic{"zigbee/lights/set"} }
+func (m testDescribedModel) State(ctx context.Context) (any, error) {
+	return m.state, nil
+}
+
+func testApiServer(t *testing.T, source run.Broker) *httptest.Server {
+	t.Helper()
+	lights := testDescribedModel{testModel: testModel{key: "lights", view: "on"}, state: map[string]any{"on": true}}
+	heater := testModel{key: "rooms/heater", view: "21°C"}
+	registry := run.NewRegistry()
+	registry.KeyToModel["lights"] = lights
+	registry.KeyToModel["rooms/heater"] = heater
+	registry.TopicToModels["lights/+"] = []run.Model{lights}
+	registry.TopicToModels["heater/set"] = []run.Model{heater}
+	registry.TopicToModels["heater/+"] = []run.Model{heater}
+
+	mux := http.NewServeMux()
+	registerApi(mux, registry, source, nil, nil)
+	server := httptest.NewServer(newAuthenticator(AuthConfig{
+		Enabled: true,
+		Tokens: []TokenConfig{
+			{Name: "admin", Token: "admin-token", Keys: []string{"*"}, Topics: []string{""}},
+			{Name: "lights", Token: "lights-token", Keys: []string{"lights"}, Topics: []string{"lights"}},
+		},
+	}).middleware(mux))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func apiRequest(t *testing.T, server *httptest.Server, method string, path string, token string, body string) (int, string) {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, server.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := doRequest(t, req)
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("%s %s: expected a JSON response, got %s", method, path, contentType)
+	}
+	res, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, strings.TrimSpace(string(res))
+}
+
+func TestApiResponses(t *testing.T) {
+	server := testApiServer(t, run.NewBroker("source", run.BrokerConfig{}))
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+		body   string
+	}{
+		{
+			name:   "apps",
+			path:   "/api/apps",
+			token:  "admin-token",
+			status: http.StatusOK,
+			body:   `[{"key":"lights","subscriptions":["lights/+"],"outputs":["zigbee/lights/set"]},{"key":"rooms/heater","subscriptions":["heater/+","heater/set"],"outputs":[]}]`,
+		},
+		{
+			name:   "scoped apps",
+			path:   "/api/apps",
+			token:  "lights-token",
+			status: http.StatusOK,
+			body:   `[{"key":"lights","subscriptions":["lights/+"],"outputs":["zigbee/lights/set"]}]`,
+		},
+		{
+			name:   "model",
+			path:   "/api/apps/lights/model",
+			token:  "lights-token",
+			status: http.StatusOK,
+			body:   `{"key":"lights","model":{"on":true}}`,
+		},
+		{
+			name:   "view",
+			path:   "/api/apps/rooms/heater/view",
+			token:  "admin-token",
+			status: http.StatusOK,
+			body:   `{"key":"rooms/heater","view":"21°C"}`,
+		},
+		{
+			name:   "undescribed model",
+			path:   "/api/apps/rooms/heater/model",
+			token:  "admin-token",
+			status: http.StatusNotImplemented,
+			body:   `{"error":"app rooms/heater does not expose its model"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := apiRequest(t, server, http.MethodGet, test.path, test.token, "")
+			if status != test.status || body != test.body {
+				t.Errorf("expected %d %s, got %d %s", test.status, test.body, status, body)
+			}
+		})
+	}
+}
+
+func TestApiPublish(t *testing.T) {
+	source := run.NewBroker("source", run.BrokerConfig{})
+	ch, unsubscribe := source.Subscribe("lights/#")
+	defer unsubscribe()
+	server := testApiServer(t, source)
+
+	tests := []struct {
+		body    string
+		payload run.Payload
+	}{
+		{body: `{"topic":"lights/kitchen","payload":{"on":true}}`, payload: `{"on":true}`},
+		{body: `{"topic":"lights/kitchen","payload":"on"}`, payload: "on"},
+	}
+	for _, test := range tests {
+		status, body := apiRequest(t, server, http.MethodPost, "/api/publish", "lights-token", test.body)
+		if status != http.StatusAccepted || body != test.body {
+			t.Errorf("expected 202 %s, got %d %s", test.body, status, body)
+		}
+		select {
+		case tp := <-ch:
+			if tp.Topic != "lights/kitchen" || tp.Payload != test.payload {
+				t.Errorf("expected %s to be published to lights/kitchen, got %v", test.payload, tp)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expected %s to be published", test.body)
+		}
+	}
+}
+
+func TestApiErrors(t *testing.T) {
+	server := testApiServer(t, run.NewBroker("source", run.BrokerConfig{}))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+		error  string
+	}{
+		{name: "missing body", method: http.MethodPost, path: "/api/publish", body: "", status: http.StatusBadRequest, error: "request body is required"},
+		{name: "invalid body", method: http.MethodPost, path: "/api/publish", body: `{"topic":`, status: http.StatusBadRequest, error: "invalid request body: unexpected EOF"},
+		{name: "unknown field", method: http.MethodPost, path: "/api/publish", body: `{"topic":"lights","qos":1}`, status: http.StatusBadRequest, error: `invalid request body: json: unknown field "qos"`},
+		{name: "missing topic", method: http.MethodPost, path: "/api/publish", body: `{"payload":"on"}`, status: http.StatusBadRequest, error: "topic is required"},
+		{name: "wildcard topic", method: http.MethodPost, path: "/api/publish", body: `{"topic":"lights/#","payload":"on"}`, status: http.StatusBadRequest, error: "topic must not contain wildcards"},
+		{name: "body too large", method: http.MethodPost, path: "/api/publish", body: `{"topic":"lights","payload":"` + strings.Repeat("x", maxPublishSize) + `"}`, status: http.StatusRequestEntityTooLarge, error: "request body too large"},
+		{name: "publish forbidden", method: http.MethodPost, path: "/api/publish", token: "lights-token", body: `{"topic":"heater/set","payload":"21"}`, status: http.StatusForbidden, error: "publishing to heater/set is not allowed"},
+		{name: "model forbidden", method: http.MethodGet, path: "/api/apps/rooms/heater/model", token: "lights-token", status: http.StatusForbidden, error: "access to app rooms/heater is not allowed"},
+		{name: "view forbidden", method: http.MethodGet, path: "/api/apps/rooms/heater/view", token: "lights-token", status: http.StatusForbidden, error: "access to app rooms/heater is not allowed"},
+		{name: "unknown app", method: http.MethodGet, path: "/api/apps/missing/view", status: http.StatusNotFound, error: "app missing not found"},
+		{name: "unknown resource", method: http.MethodGet, path: "/api/apps/lights/state", status: http.StatusNotFound, error: "not found"},
+		{name: "missing resource", method: http.MethodGet, path: "/api/apps/lights", status: http.StatusNotFound, error: "not found"},
+		{name: "unknown path", method: http.MethodGet, path: "/api/unknown", status: http.StatusNotFound, error: "not found"},
+		{name: "post apps", method: http.MethodPost, path: "/api/apps", status: http.StatusMethodNotAllowed, error: "method not allowed"},
+		{name: "delete view", method: http.MethodDelete, path: "/api/apps/lights/view", status: http.StatusMethodNotAllowed, error: "method not allowed"},
+		{name: "get publish", method: http.MethodGet, path: "/api/publish", status: http.StatusMethodNotAllowed, error: "method not allowed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := test.token
+			if token == "" {
+				token = "admin-token"
+			}
+			status, body := apiRequest(t, server, test.method, test.path, token, test.body)
+			var apiErr apiError
+			err := json.Unmarshal([]byte(body), &apiErr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != test.status || apiErr.Error != test.error {
+				t.Errorf("expected %d %q, got %d %q", test.status, test.error, status, apiErr.Error)
+			}
+		})
+	}
+}
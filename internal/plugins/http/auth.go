@@ -0,0 +1,308 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marcbran/yokai/internal/run"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	DefaultSessionCookie = "yokai_session"
+	DefaultSessionMaxAge = 7 * 24 * time.Hour
+)
+
+// AuthConfig protects every endpoint once enabled. Each credential is scoped
+// to the app keys it may read and the topic prefixes it may publish to and
+// stream, where "*" allows every key and an empty prefix every topic.
+// Prefixes match whole topic levels, so "lights" allows "lights/kitchen" but
+// not "lightsout".
+type AuthConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Tokens  []TokenConfig `mapstructure:"tokens"`
+	Users   []UserConfig  `mapstructure:"users"`
+	Session SessionConfig `mapstructure:"session"`
+}
+
+type TokenConfig struct {
+	Name   string   `mapstructure:"name"`
+	Token  string   `mapstructure:"token"`
+	Keys   []string `mapstructure:"keys"`
+	Topics []string `mapstructure:"topics"`
+}
+
+// UserConfig is a user for HTTP basic auth. PasswordHash is a bcrypt hash,
+// for example from htpasswd -nbBC 10 user password.
+type UserConfig struct {
+	Username     string   `mapstructure:"username"`
+	PasswordHash string   `mapstructure:"password_hash"`
+	Keys         []string `mapstructure:"keys"`
+	Topics       []string `mapstructure:"topics"`
+}
+
+// SessionConfig enables a signed session cookie, which browsers get after
+// logging in with basic auth, so that views, websockets and event streams
+// keep working without resending the password. Without a secret there are
+// no sessions.
+type SessionConfig struct {
+	Secret string        `mapstructure:"secret"`
+	Cookie string        `mapstructure:"cookie"`
+	MaxAge time.Duration `mapstructure:"max_age"`
+	Secure bool          `mapstructure:"secure"`
+}
+
+// CredentialsConfig is what internal/client sends to the server.
+type CredentialsConfig struct {
+	Token    string `mapstructure:"token"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
+type principal struct {
+	name   string
+	keys   []string
+	topics []string
+}
+
+func (p *principal) allowsKey(key run.Key) bool {
+	if p == nil {
+		return true
+	}
+	for _, allowed := range p.keys {
+		if allowed == "*" || allowed == key {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsTopic reports whether topic lies under one of the prefixes. Stream
+// filters are checked the same way, so that a wildcard is only allowed below
+// a prefix.
+func (p *principal) allowsTopic(topic run.Topic) bool {
+	if p == nil {
+		return true
+	}
+	for _, prefix := range p.topics {
+		if _, ok := run.CutTopicPrefix(topic, prefix); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+func principalFrom(r *http.Request) *principal {
+	p, _ := r.Context().Value(principalKey{}).(*principal)
+	return p
+}
+
+func allowedKey(w http.ResponseWriter, r *http.Request, key run.Key) bool {
+	if principalFrom(r).allowsKey(key) {
+		return true
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+func allowedTopic(w http.ResponseWriter, r *http.Request, topic run.Topic) bool {
+	if principalFrom(r).allowsTopic(topic) {
+		return true
+	}
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return false
+}
+
+type authenticator struct {
+	config AuthConfig
+}
+
+func newAuthenticator(config AuthConfig) *authenticator {
+	if config.Session.Cookie == "" {
+		config.Session.Cookie = DefaultSessionCookie
+	}
+	if config.Session.MaxAge <= 0 {
+		config.Session.MaxAge = DefaultSessionMaxAge
+	}
+	return &authenticator{config: config}
+}
+
+// middleware rejects requests without valid credentials and stores the
+// principal of all others in the request context for the handlers to check
+// their scopes.
+func (a *authenticator) middleware(next http.Handler) http.Handler {
+	if !a.config.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth/logout" {
+			a.logout(w, r)
+			return
+		}
+
+		p, basic := a.authenticate(r)
+		if p == nil {
+			if len(a.config.Users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="yokai", charset="UTF-8"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if basic && a.config.Session.Secret != "" {
+			a.startSession(w, p)
+		}
+		if r.URL.Path == "/auth/login" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// authenticate tries a bearer token, basic auth, the session cookie and an
+// access_token query parameter, which EventSource and WebSocket clients can
+// send where they cannot set headers. It reports whether basic auth was
+// used.
+func (a *authenticator) authenticate(r *http.Request) (*principal, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return a.token(token), false
+	}
+	if username, password, ok := r.BasicAuth(); ok {
+		return a.user(username, password), true
+	}
+	if cookie, err := r.Cookie(a.config.Session.Cookie); err == nil && a.config.Session.Secret != "" {
+		p, err := a.session(cookie.Value)
+		if err != nil {
+			log.WithError(err).
+				Debug("ignoring invalid session cookie")
+		}
+		if p != nil {
+			return p, false
+		}
+	}
+	if token := r.URL.Query().Get("access_token"); token != "" && r.Method == http.MethodGet {
+		return a.token(token), false
+	}
+	return nil, false
+}
+
+func (a *authenticator) token(token string) *principal {
+	for _, config := range a.config.Tokens {
+		if config.Token != "" && subtle.ConstantTimeCompare([]byte(config.Token), []byte(token)) == 1 {
+			return &principal{name: "token:" + config.Name, keys: config.Keys, topics: config.Topics}
+		}
+	}
+	return nil
+}
+
+func (a *authenticator) user(username string, password string) *principal {
+	for _, config := range a.config.Users {
+		if config.Username != username {
+			continue
+		}
+		err := bcrypt.CompareHashAndPassword([]byte(config.PasswordHash), []byte(password))
+		if err != nil {
+			return nil
+		}
+		return a.userPrincipal(config)
+	}
+	return nil
+}
+
+func (a *authenticator) userPrincipal(config UserConfig) *principal {
+	return &principal{name: "user:" + config.Username, keys: config.Keys, topics: config.Topics}
+}
+
+type sessionClaims struct {
+	Username string `json:"u"`
+	Expires  int64  `json:"e"`
+}
+
+// startSession sets a cookie holding the username and expiry, signed with
+// HMAC-SHA256. Scopes are looked up again on every request, so that config
+// changes apply to existing sessions.
+func (a *authenticator) startSession(w http.ResponseWriter, p *principal) {
+	username, ok := strings.CutPrefix(p.name, "user:")
+	if !ok {
+		return
+	}
+	b, err := json.Marshal(sessionClaims{
+		Username: username,
+		Expires:  time.Now().Add(a.config.Session.MaxAge).Unix(),
+	})
+	if err != nil {
+		log.WithError(err).
+			Error("failed to encode session")
+		return
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.config.Session.Cookie,
+		Value:    payload + "." + a.sign(payload),
+		Path:     "/",
+		MaxAge:   int(a.config.Session.MaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   a.config.Session.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *authenticator) session(value string) (*principal, error) {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, errors.New("malformed session")
+	}
+	if !hmac.Equal([]byte(signature), []byte(a.sign(payload))) {
+		return nil, errors.New("invalid session signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	var claims sessionClaims
+	err = json.Unmarshal(b, &claims)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > claims.Expires {
+		return nil, errors.New("session expired")
+	}
+	for _, config := range a.config.Users {
+		if config.Username == claims.Username {
+			return a.userPrincipal(config), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown session user %s", claims.Username)
+}
+
+func (a *authenticator) sign(payload string) string {
+	mac := hmac.New(sha256.New, []byte(a.config.Session.Secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *authenticator) logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     a.config.Session.Cookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   a.config.Session.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
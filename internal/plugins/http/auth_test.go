@@ -0,0 +1,210 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcbran/yokai/internal/run"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testAuthConfig(t *testing.T) AuthConfig {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return AuthConfig{
+		Enabled: true,
+		Tokens: []TokenConfig{
+			{Name: "lights", Token: "lights-token", Keys: []string{"lights"}, Topics: []string{"lights"}},
+		},
+		Users: []UserConfig{
+			{Username: "alice", PasswordHash: string(hash), Keys: []string{"*"}, Topics: []string{""}},
+		},
+		Session: SessionConfig{Secret: "secret"},
+	}
+}
+
+func testAuthServer(t *testing.T, config AuthConfig) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	registerApi(mux, run.NewRegistry(), run.NewBroker("source", run.BrokerConfig{}), nil, nil)
+	mux.HandleFunc("/sse/", handleSse(Config{}, map[run.Key]run.Model{}, nil, nil))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	server := httptest.NewServer(newAuthenticator(config).middleware(mux))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func mustRequest(t *testing.T, method string, url string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func doRequest(t *testing.T, req *http.Request) *http.Response {
+	t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = resp.Body.Close()
+	})
+	return resp
+}
+
+func TestAuthBasic(t *testing.T) {
+	server := testAuthServer(t, testAuthConfig(t))
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		status   int
+	}{
+		{name: "valid", username: "alice", password: "password", status: http.StatusOK},
+		{name: "wrong password", username: "alice", password: "wrong", status: http.StatusUnauthorized},
+		{name: "unknown user", username: "bob", password: "password", status: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := mustRequest(t, http.MethodGet, server.URL+"/")
+			req.SetBasicAuth(test.username, test.password)
+			resp := doRequest(t, req)
+			if resp.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d", test.status, resp.StatusCode)
+			}
+			if test.status == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Error("expected a basic auth challenge")
+			}
+		})
+	}
+}
+
+func TestAuthBearer(t *testing.T) {
+	server := testAuthServer(t, testAuthConfig(t))
+
+	for token, status := range map[string]int{
+		"lights-token": http.StatusOK,
+		"other-token":  http.StatusUnauthorized,
+	} {
+		req := mustRequest(t, http.MethodGet, server.URL+"/")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := doRequest(t, req)
+		if resp.StatusCode != status {
+			t.Errorf("token %s: expected status %d, got %d", token, status, resp.StatusCode)
+		}
+	}
+
+	resp := doRequest(t, mustRequest(t, http.MethodGet, server.URL+"/"))
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected requests without credentials to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuthSessionCookie(t *testing.T) {
+	config := testAuthConfig(t)
+	server := testAuthServer(t, config)
+
+	login := mustRequest(t, http.MethodPost, server.URL+"/auth/login")
+	login.SetBasicAuth("alice", "password")
+	resp := doRequest(t, login)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected login to succeed, got %d", resp.StatusCode)
+	}
+	var session *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == DefaultSessionCookie {
+			session = cookie
+		}
+	}
+	if session == nil {
+		t.Fatal("expected a session cookie")
+	}
+
+	withCookie := func(value string) int {
+		req := mustRequest(t, http.MethodGet, server.URL+"/")
+		req.AddCookie(&http.Cookie{Name: DefaultSessionCookie, Value: value})
+		return doRequest(t, req).StatusCode
+	}
+
+	if status := withCookie(session.Value); status != http.StatusOK {
+		t.Errorf("expected the session to authenticate, got %d", status)
+	}
+
+	// The claims are changed to a later expiry while keeping the signature.
+	_, signature, _ := strings.Cut(session.Value, ".")
+	claims, _ := json.Marshal(sessionClaims{Username: "alice", Expires: time.Now().Add(365 * 24 * time.Hour).Unix()})
+	tampered := base64.RawURLEncoding.EncodeToString(claims) + "." + signature
+	if status := withCookie(tampered); status != http.StatusUnauthorized {
+		t.Errorf("expected a tampered session to be rejected, got %d", status)
+	}
+
+	a := newAuthenticator(config)
+	claims, _ = json.Marshal(sessionClaims{Username: "alice", Expires: time.Now().Add(-time.Minute).Unix()})
+	expired := base64.RawURLEncoding.EncodeToString(claims)
+	if status := withCookie(expired + "." + a.sign(expired)); status != http.StatusUnauthorized {
+		t.Errorf("expected an expired session to be rejected, got %d", status)
+	}
+}
+
+func TestAuthTopicScopes(t *testing.T) {
+	server := testAuthServer(t, testAuthConfig(t))
+
+	publish := func(topic string) int {
+		body := strings.NewReader(`{"topic":"` + topic + `","payload":{"on":true}}`)
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/publish", body)
+		req.Header.Set("Authorization", "Bearer lights-token")
+		return doRequest(t, req).StatusCode
+	}
+	for topic, status := range map[string]int{
+		"lights":         http.StatusAccepted,
+		"lights/kitchen": http.StatusAccepted,
+		"lightsout":      http.StatusForbidden,
+		"heating":        http.StatusForbidden,
+	} {
+		if got := publish(topic); got != status {
+			t.Errorf("publishing to %s: expected status %d, got %d", topic, status, got)
+		}
+	}
+
+	for _, filter := range []string{"lightsout/%23", "%23", "%2B/kitchen"} {
+		req := mustRequest(t, http.MethodGet, server.URL+"/sse/topics?filter="+filter)
+		req.Header.Set("Authorization", "Bearer lights-token")
+		if status := doRequest(t, req).StatusCode; status != http.StatusForbidden {
+			t.Errorf("streaming %s: expected status %d, got %d", filter, http.StatusForbidden, status)
+		}
+	}
+}
+
+func TestPrincipalAllowsTopic(t *testing.T) {
+	p := &principal{topics: []string{"lights", "zigbee2mqtt/"}}
+	for topic, allowed := range map[run.Topic]bool{
+		"lights":                true,
+		"lights/kitchen":        true,
+		"lights/#":              true,
+		"lightsout":             false,
+		"zigbee2mqtt/remote":    true,
+		"zigbee2mqttbridge/log": false,
+		"#":                     false,
+	} {
+		if got := p.allowsTopic(topic); got != allowed {
+			t.Errorf("allowsTopic(%s) = %v, expected %v", topic, got, allowed)
+		}
+	}
+	if !(*principal)(nil).allowsTopic("anything") {
+		t.Error("expected no principal to allow every topic")
+	}
+}
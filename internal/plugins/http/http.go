@@ -5,7 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/marcbran/yokai/internal/run"
@@ -18,6 +22,11 @@ type Config struct {
 	Scheme   string `mapstructure:"scheme"`
 	Hostname string `mapstructure:"hostname"`
 	Port     int    `mapstructure:"port"`
+
+	SsePingInterval time.Duration `mapstructure:"sse_ping_interval"`
+
+	Auth        AuthConfig        `mapstructure:"auth"`
+	Credentials CredentialsConfig `mapstructure:"credentials"`
 }
 
 type Plugin struct {
@@ -40,7 +49,7 @@ func (h *Plugin) Start(ctx context.Context, g *errgroup.Group, registry run.Regi
 		httpCtx, httpCancel := context.WithCancel(ctx)
 		defer httpCancel()
 
-		err := runHttpServer(httpCtx, h.config, registry.KeyToModel, source, view)
+		err := runHttpServer(httpCtx, h.config, registry, source, view, sink)
 		if err != nil && !errors.Is(err, context.Canceled) {
 			return err
 		}
@@ -51,22 +60,30 @@ func (h *Plugin) Start(ctx context.Context, g *errgroup.Group, registry run.Regi
 func runHttpServer(
 	ctx context.Context,
 	config Config,
-	keyToModel map[run.Key]run.Model,
+	registry run.Registry,
 	source run.Broker,
 	view run.Broker,
+	sink run.Broker,
 ) error {
 	mux := http.NewServeMux()
 
-	for key, model := range keyToModel {
+	registerApi(mux, registry, source, view, sink)
+	mux.HandleFunc("/sse/", handleSse(config, registry.KeyToModel, view, sink))
+	for key, model := range registry.KeyToModel {
 		mux.HandleFunc("/"+key, handleGet(model, key))
-		mux.HandleFunc("/ws/"+key, handleWs(model, key, source, view))
+		mux.HandleFunc("/ws/"+key, handleWs(config, model, key, source, view))
 	}
 
 	mux.HandleFunc("/", handleWildcardPost(source))
 
+	// Requests derive their context from the run, so that streams end when
+	// the run does. Shutdown itself does not cancel running handlers.
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.Port),
-		Handler: mux,
+		Handler: newAuthenticator(config.Auth).middleware(mux),
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
 	}
 
 	go func() {
@@ -93,6 +110,9 @@ func handleGet(model run.Model, key run.Key) func(w http.ResponseWriter, r *http
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		if !allowedKey(w, r, key) {
+			return
+		}
 
 		view, err := model.View(r.Context())
 		if err != nil {
@@ -112,16 +132,43 @@ func handleGet(model run.Model, key run.Key) func(w http.ResponseWriter, r *http
 	}
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
+// newUpgrader checks the origin of websocket upgrades once auth is enabled,
+// so that another site cannot open a websocket with the session cookie of a
+// logged in user. Without auth there is nothing to protect.
+func newUpgrader(auth AuthConfig) *websocket.Upgrader {
+	upgrader := &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+	if auth.Enabled {
+		upgrader.CheckOrigin = sameOrigin
+	}
+	return upgrader
+}
+
+// sameOrigin allows requests whose Origin names the requested host. Clients
+// other than browsers send no Origin and are allowed as well.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
 		return true
-	},
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
 }
 
-func handleWs(model run.Model, key run.Key, source run.Broker, view run.Broker) func(w http.ResponseWriter, r *http.Request) {
+func handleWs(config Config, model run.Model, key run.Key, source run.Broker, view run.Broker) func(w http.ResponseWriter, r *http.Request) {
+	upgrader := newUpgrader(config.Auth)
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowedKey(w, r, key) {
+			return
+		}
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.WithError(err).
@@ -140,7 +187,7 @@ func handleWs(model run.Model, key run.Key, source run.Broker, view run.Broker)
 
 		g, gCtx := errgroup.WithContext(r.Context())
 		g.Go(func() error {
-			views, unsubscribe := view.Subscribe(key)
+			views, unsubscribe := view.Subscribe(key, run.WithPolicy(run.PolicyDropOldest))
 			defer unsubscribe()
 
 			for {
@@ -151,7 +198,7 @@ func handleWs(model run.Model, key run.Key, source run.Broker, view run.Broker)
 					if !ok {
 						return nil
 					}
-					err := conn.WriteMessage(websocket.TextMessage, []byte(view))
+					err := conn.WriteMessage(websocket.TextMessage, []byte(view.Payload))
 					if err != nil {
 						log.WithError(err).
 							WithField("key", key).
@@ -200,6 +247,9 @@ func handleWildcardPost(source run.Broker) func(w http.ResponseWriter, r *http.R
 		}
 
 		topic = topic[1:]
+		if !allowedTopic(w, r, topic) {
+			return
+		}
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/marcbran/yokai/internal/run"
+)
+
+func TestWsChecksOriginWithAuth(t *testing.T) {
+	view := run.NewBroker("view", run.BrokerConfig{})
+	source := run.NewBroker("source", run.BrokerConfig{})
+	model := testModel{key: "lights", view: "on"}
+
+	for _, enabled := range []bool{true, false} {
+		config := Config{Auth: AuthConfig{
+			Enabled: enabled,
+			Tokens:  []TokenConfig{{Name: "lights", Token: "lights-token", Keys: []string{"lights"}}},
+		}}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/ws/lights", handleWs(config, model, "lights", source, view))
+		server := httptest.NewServer(newAuthenticator(config.Auth).middleware(mux))
+		defer server.Close()
+
+		tests := map[string]bool{
+			"":                          true,
+			server.URL:                  true,
+			strings.ToUpper(server.URL): true,
+			"https://evil.example.com":  !enabled,
+			"http://127.0.0.1:1":        !enabled,
+		}
+		for origin, allowed := range tests {
+			header := http.Header{}
+			header.Set("Authorization", "Bearer lights-token")
+			if origin != "" {
+				header.Set("Origin", origin)
+			}
+			conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/lights", header)
+			if conn != nil {
+				_ = conn.Close()
+			}
+			if allowed && err != nil {
+				t.Errorf("auth %v, origin %q: expected the upgrade to succeed, got %v", enabled, origin, err)
+			}
+			if !allowed && (err == nil || resp == nil || resp.StatusCode != http.StatusForbidden) {
+				t.Errorf("auth %v, origin %q: expected the upgrade to be forbidden, got %v", enabled, origin, err)
+			}
+		}
+	}
+}
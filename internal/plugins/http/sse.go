@@ -0,0 +1,190 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marcbran/yokai/internal/run"
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultSsePingInterval = 15 * time.Second
+
+type sseMessage struct {
+	Topic   run.Topic `json:"topic"`
+	Payload any       `json:"payload"`
+}
+
+// handleSse serves /sse/<key>, which streams the views of an app, and
+// /sse/topics?filter=..., which streams sink messages matching any of the
+// given filters. The topics stream takes precedence over an app named topics.
+func handleSse(config Config, keyToModel map[run.Key]run.Model, view run.Broker, sink run.Broker) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, ok := w.(http.Flusher); !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		key := strings.TrimPrefix(r.URL.Path, "/sse/")
+		if key == "topics" {
+			filters := r.URL.Query()["filter"]
+			if len(filters) == 0 {
+				http.Error(w, "Bad request: filter is required", http.StatusBadRequest)
+				return
+			}
+			for _, filter := range filters {
+				if !allowedTopic(w, r, filter) {
+					return
+				}
+			}
+			streamTopics(w, r, config, filters, sink)
+			return
+		}
+
+		model, ok := keyToModel[key]
+		if !ok {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		if !allowedKey(w, r, key) {
+			return
+		}
+		streamViews(w, r, config, key, model, view)
+	}
+}
+
+func streamViews(w http.ResponseWriter, r *http.Request, config Config, key run.Key, model run.Model, view run.Broker) {
+	views, unsubscribe := view.Subscribe(key, run.WithPolicy(run.PolicyDropOldest))
+	defer unsubscribe()
+
+	current, err := model.View(r.Context())
+	if err != nil {
+		log.WithError(err).
+			WithField("key", key).
+			Error("failed to handle view")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	startSse(w)
+	err = writeSseEvent(w, "view", current)
+	if err != nil {
+		return
+	}
+
+	log.WithField("key", key).
+		Info("streaming views")
+	streamSse(w, r, config, key, views, func(tp run.TopicPayload) (string, string, error) {
+		return "view", tp.Payload, nil
+	})
+}
+
+func streamTopics(w http.ResponseWriter, r *http.Request, config Config, filters []run.Topic, sink run.Broker) {
+	messages, unsubscribe := sink.SubscribeAll(run.WithPolicy(run.PolicyDropOldest))
+	defer unsubscribe()
+
+	startSse(w)
+	log.WithField("filters", filters).
+		Info("streaming topics")
+	streamSse(w, r, config, strings.Join(filters, ","), messages, func(tp run.TopicPayload) (string, string, error) {
+		if !run.MatchesAny(filters, tp.Topic) {
+			return "", "", nil
+		}
+		var payload any = tp.Payload
+		var value any
+		if json.Unmarshal([]byte(tp.Payload), &value) == nil {
+			payload = value
+		}
+		b, err := json.Marshal(sseMessage{Topic: tp.Topic, Payload: payload})
+		if err != nil {
+			return "", "", err
+		}
+		return "message", string(b), nil
+	})
+}
+
+func startSse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+}
+
+// streamSse writes an event for every message until the client disconnects,
+// the run ends, which cancels the request context too, or the channel
+// closes, with a ping comment whenever the stream was idle for the ping
+// interval. Messages for which event returns an empty name are
+// skipped.
+func streamSse(
+	w http.ResponseWriter,
+	r *http.Request,
+	config Config,
+	name string,
+	ch <-chan run.TopicPayload,
+	event func(tp run.TopicPayload) (string, string, error),
+) {
+	interval := config.SsePingInterval
+	if interval <= 0 {
+		interval = DefaultSsePingInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			log.WithField("stream", name).
+				Info("sse stream closed")
+			return
+		case <-ticker.C:
+			_, err := fmt.Fprint(w, ": ping\n\n")
+			if err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		case tp, ok := <-ch:
+			if !ok {
+				return
+			}
+			eventName, data, err := event(tp)
+			if err != nil {
+				log.WithError(err).
+					WithField("stream", name).
+					WithField("topic", tp.Topic).
+					Error("failed to encode sse event")
+				continue
+			}
+			if eventName == "" {
+				continue
+			}
+			err = writeSseEvent(w, eventName, data)
+			if err != nil {
+				return
+			}
+			ticker.Reset(interval)
+		}
+	}
+}
+
+func writeSseEvent(w http.ResponseWriter, event string, data string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "event: %s\n", event)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	_, err := fmt.Fprint(w, b.String())
+	if err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/marcbran/yokai/internal/run"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestSseStreamsEndWithTheRun(t *testing.T) {
+	config := Config{Enabled: true, Port: freePort(t)}
+	sink := run.NewBroker("sink", run.BrokerConfig{})
+	view := run.NewBroker("view", run.BrokerConfig{})
+	source := run.NewBroker("source", run.BrokerConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- runHttpServer(ctx, config, run.NewRegistry(), source, view, sink)
+	}()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/sse/topics?filter=%%23", config.Port)
+	var resp *http.Response
+	for range 50 {
+		var err error
+		resp, err = http.Get(url)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp == nil {
+		t.Fatal("server did not start")
+	}
+	defer resp.Body.Close()
+
+	sink.Publish("lights/kitchen", `{"on":true}`)
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "event: message\n" {
+		t.Fatalf("expected a message event, got %q, %v", line, err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the server to shut down while a stream is open")
+	}
+	ended := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(reader)
+		ended <- err
+	}()
+	select {
+	case err := <-ended:
+		if err != nil {
+			t.Fatalf("expected the stream to end cleanly, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the stream to end with the run")
+	}
+}
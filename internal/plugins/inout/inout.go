@@ -36,11 +36,13 @@ func (i *InoutPlugin) Start(ctx context.Context, g *errgroup.Group, registry run
 		}
 		return nil
 	})
+	ch, unsubscribe := sink.SubscribeAll(run.WithPolicy(run.PolicyUnbounded))
 	g.Go(func() error {
 		outCtx, outCancel := context.WithCancel(ctx)
 		defer outCancel()
+		defer unsubscribe()
 
-		err := runOut(outCtx, i, sink)
+		err := runOut(outCtx, i, ch)
 		if err != nil && !errors.Is(err, context.Canceled) {
 			return err
 		}
@@ -70,11 +72,8 @@ func runIn(
 func runOut(
 	ctx context.Context,
 	plugin *InoutPlugin,
-	sink run.Broker,
+	ch <-chan run.TopicPayload,
 ) error {
-	ch, unsubscribe := sink.SubscribeAll()
-	defer unsubscribe()
-
 	for {
 		select {
 		case <-ctx.Done():
@@ -3,24 +3,61 @@ package loop
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/marcbran/yokai/internal/run"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 )
 
-type LoopPlugin struct{}
+const DefaultMaxHops = run.DefaultMaxHops
 
-func NewPlugin() *LoopPlugin {
-	return &LoopPlugin{}
+// ErrorKey is the key under which dropped messages are reported to the
+// error topic. It is reserved, so that it cannot collide with an app.
+const ErrorKey = run.ReservedKeyPrefix + "loop"
+
+type Config struct {
+	Enabled  bool     `mapstructure:"enabled"`
+	Prefixes []string `mapstructure:"prefixes"`
+	// MaxHops also limits chains that pass through commands, see
+	// run.CommanderPlugin.
+	MaxHops int `mapstructure:"max_hops"`
+}
+
+type LoopPlugin struct {
+	config Config
+	errors run.ErrorConfig
 }
 
-func (l *LoopPlugin) Start(ctx context.Context, g *errgroup.Group, registry run.Registry, source run.Broker, sink run.Broker) {
+func NewPlugin(config Config, errors run.ErrorConfig) *LoopPlugin {
+	if config.MaxHops <= 0 {
+		config.MaxHops = DefaultMaxHops
+	}
+	return &LoopPlugin{
+		config: config,
+		errors: errors,
+	}
+}
+
+func (l *LoopPlugin) Start(ctx context.Context, g *errgroup.Group, registry run.Registry, source run.Broker, view run.Broker, sink run.Broker) {
+	if !l.config.Enabled {
+		log.Info("Loop plugin is disabled")
+		return
+	}
+
+	var filters []run.Topic
+	for filter := range registry.TopicToModels {
+		filters = append(filters, filter)
+	}
+
+	ch, unsubscribe := sink.SubscribeAll(run.WithPolicy(run.PolicyUnbounded))
 	g.Go(func() error {
 		loopCtx, loopCancel := context.WithCancel(ctx)
 		defer loopCancel()
+		defer unsubscribe()
 
-		err := runLoop(loopCtx, source, sink)
+		err := runLoop(loopCtx, l.config, l.errors, filters, ch, source, sink)
 		if err != nil && !errors.Is(err, context.Canceled) {
 			return err
 		}
@@ -30,12 +67,13 @@ func (l *LoopPlugin) Start(ctx context.Context, g *errgroup.Group, registry run.
 
 func runLoop(
 	ctx context.Context,
+	config Config,
+	errorConfig run.ErrorConfig,
+	filters []run.Topic,
+	ch <-chan run.TopicPayload,
 	source run.Broker,
 	sink run.Broker,
 ) error {
-	ch, unsubscribe := sink.SubscribeAll()
-	defer unsubscribe()
-
 	for {
 		select {
 		case <-ctx.Done():
@@ -44,12 +82,34 @@ func runLoop(
 			if !ok {
 				return nil
 			}
+			if !routes(config.Prefixes, filters, tp.Topic) {
+				continue
+			}
+			if tp.Hops >= config.MaxHops {
+				log.WithField("topic", tp.Topic).
+					WithField("origin", tp.Origin).
+					WithField("hops", tp.Hops).
+					Warn("dropping message that exceeded max hops")
+				err := fmt.Errorf("message exceeded max hops of %d", config.MaxHops)
+				run.ReportError(errorConfig, sink, ErrorKey, "loop", tp, err)
+				continue
+			}
 
 			log.WithField("topic", tp.Topic).
 				WithField("payload", tp.Payload).
 				Info("looping message from sink to source")
 
-			source.Publish(tp.Topic, tp.Payload)
+			tp.Hops++
+			source.PublishMessage(tp)
+		}
+	}
+}
+
+func routes(prefixes []string, filters []run.Topic, topic run.Topic) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(topic, prefix) {
+			return true
 		}
 	}
+	return run.MatchesAny(filters, topic)
 }
@@ -0,0 +1,54 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/marcbran/yokai/internal/run"
+)
+
+const echoWindow = 5 * time.Second
+
+// echoes remembers messages published to the broker, so that the copies the
+// broker delivers back to our own subscriptions can be dropped. Those
+// messages already reached the apps through the loop plugin.
+type echoes struct {
+	mu      sync.Mutex
+	pending map[run.TopicPayload][]time.Time
+}
+
+func newEchoes() *echoes {
+	return &echoes{
+		pending: make(map[run.TopicPayload][]time.Time),
+	}
+}
+
+func (e *echoes) record(topic run.Topic, payload run.Payload) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := run.TopicPayload{Topic: topic, Payload: payload}
+	e.pending[key] = append(e.pending[key], time.Now().Add(echoWindow))
+}
+
+func (e *echoes) consume(topic run.Topic, payload run.Payload) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := run.TopicPayload{Topic: topic, Payload: payload}
+	now := time.Now()
+	expiries := e.pending[key]
+	for len(expiries) > 0 && expiries[0].Before(now) {
+		expiries = expiries[1:]
+	}
+	if len(expiries) == 0 {
+		delete(e.pending, key)
+		return false
+	}
+	if len(expiries) == 1 {
+		delete(e.pending, key)
+	} else {
+		e.pending[key] = expiries[1:]
+	}
+	return true
+}
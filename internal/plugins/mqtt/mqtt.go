@@ -11,12 +11,24 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+const DefaultPublishTimeout = 5 * time.Second
+
 type Config struct {
 	Enabled     bool          `mapstructure:"enabled"`
 	Broker      string        `mapstructure:"broker"`
 	ClientId    string        `mapstructure:"clientId"`
 	KeepAlive   time.Duration `mapstructure:"keep_alive"`
 	PingTimeout time.Duration `mapstructure:"ping_timeout"`
+
+	// PublishTimeout bounds the wait for the broker to acknowledge a
+	// message, after which the message is given up and the next one is
+	// published.
+	PublishTimeout time.Duration `mapstructure:"publish_timeout"`
+
+	// SuppressEcho drops messages the broker echoes back after we published
+	// them. Only enable it together with the loop plugin, which then delivers
+	// those messages to the apps instead. It defaults to loop.enabled.
+	SuppressEcho bool `mapstructure:"suppress_echo"`
 }
 
 type MqttPlugin struct {
@@ -24,6 +36,9 @@ type MqttPlugin struct {
 }
 
 func NewPlugin(config Config) *MqttPlugin {
+	if config.PublishTimeout <= 0 {
+		config.PublishTimeout = DefaultPublishTimeout
+	}
 	return &MqttPlugin{
 		config: config,
 	}
@@ -35,21 +50,30 @@ func (m *MqttPlugin) Start(ctx context.Context, g *errgroup.Group, registry run.
 		return
 	}
 
+	var echoes *echoes
+	if m.config.SuppressEcho {
+		echoes = newEchoes()
+	}
+
 	g.Go(func() error {
 		mqttCtx, mqttCancel := context.WithCancel(ctx)
 		defer mqttCancel()
 
-		err := runMqttSub(mqttCtx, m.config, registry.TopicToModels, source)
+		err := runMqttSub(mqttCtx, m.config, registry.TopicToModels, echoes, source)
 		if err != nil && !errors.Is(err, context.Canceled) {
 			return err
 		}
 		return nil
 	})
+	// The publisher has its own queue, which drops the oldest messages while
+	// the broker is unreachable, so that an outage never holds up the apps.
+	ch, unsubscribe := sink.SubscribeAll(run.WithPolicy(run.PolicyDropOldest))
 	g.Go(func() error {
 		mqttCtx, mqttCancel := context.WithCancel(ctx)
 		defer mqttCancel()
+		defer unsubscribe()
 
-		err := runMqttPub(mqttCtx, m.config, sink)
+		err := runMqttPub(mqttCtx, m.config, registry.TopicToModels, echoes, ch)
 		if err != nil && !errors.Is(err, context.Canceled) {
 			return err
 		}
@@ -61,6 +85,7 @@ func runMqttSub(
 	ctx context.Context,
 	config Config,
 	topicToModels map[run.Topic][]run.Model,
+	echoes *echoes,
 	source run.Broker,
 ) error {
 	client := mqtt.NewClient(mqtt.NewClientOptions().
@@ -94,6 +119,16 @@ func runMqttSub(
 
 		topic := msg.Topic()
 		payload := string(msg.Payload())
+		if !run.MatchesAny(topics, topic) {
+			log.WithField("topic", topic).
+				Debug("ignoring message not matching any filter")
+			return
+		}
+		if echoes != nil && echoes.consume(topic, payload) {
+			log.WithField("topic", topic).
+				Debug("ignoring echo of published message")
+			return
+		}
 		log.WithField("topic", topic).
 			WithField("payload", payload).
 			Info("received message from topic")
@@ -116,7 +151,18 @@ func runMqttSub(
 	return g.Wait()
 }
 
-func runMqttPub(ctx context.Context, config Config, sink run.Broker) error {
+func runMqttPub(
+	ctx context.Context,
+	config Config,
+	topicToModels map[run.Topic][]run.Model,
+	echoes *echoes,
+	ch <-chan run.TopicPayload,
+) error {
+	var topics []run.Topic
+	for topic := range topicToModels {
+		topics = append(topics, topic)
+	}
+
 	client := mqtt.NewClient(mqtt.NewClientOptions().
 		AddBroker(config.Broker).
 		SetClientID(config.ClientId).
@@ -130,32 +176,38 @@ func runMqttPub(ctx context.Context, config Config, sink run.Broker) error {
 
 	defer client.Disconnect(250)
 
-	ch, unsubscribe := sink.SubscribeAll()
-	defer unsubscribe()
-
-	g, gCtx := errgroup.WithContext(ctx)
-
+	// Messages are published one at a time, waiting for each token, so that
+	// they reach the broker in the order the apps produced them. A message
+	// the broker does not acknowledge in time is given up.
 	for {
 		select {
-		case <-gCtx.Done():
-			return g.Wait()
+		case <-ctx.Done():
+			return ctx.Err()
 		case tp, ok := <-ch:
 			if !ok {
-				return g.Wait()
+				return nil
+			}
+
+			if echoes != nil && run.MatchesAny(topics, tp.Topic) {
+				echoes.record(tp.Topic, tp.Payload)
 			}
 
-			g.Go(func() error {
-				log.WithField("topic", tp.Topic).
-					WithField("payload", tp.Payload).
-					Info("publishing message to topic")
-				err := wait(gCtx, client.Publish(tp.Topic, 0, false, tp.Payload))
-				if err != nil {
-					log.WithError(err).
-						WithField("topic", tp.Topic).
-						Error("failed to publish message to topic")
+			log.WithField("topic", tp.Topic).
+				WithField("payload", tp.Payload).
+				WithField("qos", tp.Qos).
+				WithField("retain", tp.Retain).
+				Info("publishing message to topic")
+			publishCtx, publishCancel := context.WithTimeout(ctx, config.PublishTimeout)
+			err := wait(publishCtx, client.Publish(tp.Topic, tp.Qos, tp.Retain, tp.Payload))
+			publishCancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
 				}
-				return nil
-			})
+				log.WithError(err).
+					WithField("topic", tp.Topic).
+					Error("failed to publish message to topic")
+			}
 		}
 	}
 }
@@ -0,0 +1,34 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type pendingToken struct {
+	done chan struct{}
+}
+
+func (p pendingToken) Wait() bool                       { <-p.done; return true }
+func (p pendingToken) WaitTimeout(d time.Duration) bool { return false }
+func (p pendingToken) Done() <-chan struct{}            { return p.done }
+func (p pendingToken) Error() error                     { return nil }
+
+func TestWaitGivesUpAfterTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := wait(ctx, pendingToken{done: make(chan struct{})})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the wait to time out, got %v", err)
+	}
+}
+
+func TestNewPluginDefaultsPublishTimeout(t *testing.T) {
+	plugin := NewPlugin(Config{})
+	if plugin.config.PublishTimeout != DefaultPublishTimeout {
+		t.Errorf("expected the default publish timeout, got %v", plugin.config.PublishTimeout)
+	}
+}
@@ -4,14 +4,24 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/marcbran/jsonnet-kit/pkg/jsonnext"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/google/go-jsonnet"
 )
 
+// ReservedKeyPrefix marks keys that yokai uses for itself, for example to
+// report errors that do not belong to an app. Apps cannot use them.
+const ReservedKeyPrefix = "yokai/"
+
 type AppConfig struct {
 	Config string   `mapstructure:"config"`
 	Vendor []string `mapstructure:"vendor"`
@@ -19,79 +29,325 @@ type AppConfig struct {
 
 type AppRegistration struct {
 	appLib AppLib
+	store  StateStore
+	models *sync.Map
+	inits  map[Key]any
 }
 
-func NewAppRegistration(config AppConfig) *AppRegistration {
+func NewAppRegistration(config AppConfig, store StateStore, clock Clock) *AppRegistration {
 	return &AppRegistration{
 		appLib: AppLib{
 			config: config.Config,
 			vendor: config.Vendor,
+			clock:  clock,
 		},
+		store:  store,
+		models: &sync.Map{},
+		inits:  make(map[Key]any),
 	}
 }
 
-func (a AppRegistration) Register() (Registry, error) {
+func (a *AppRegistration) Register() (Registry, error) {
 	apps, err := a.appLib.listApps()
 	if err != nil {
 		return Registry{}, err
 	}
 
-	var models sync.Map
+	for key := range apps {
+		if strings.HasPrefix(key, ReservedKeyPrefix) {
+			return Registry{}, fmt.Errorf("app key %s is reserved, keys must not start with %s", key, ReservedKeyPrefix)
+		}
+	}
+
+	for _, cycle := range findCycles(apps) {
+		log.WithField("keys", cycle.Keys).
+			WithField("topics", cycle.Topics).
+			Warn("apps form a topic cycle")
+	}
+
+	a.models.Range(func(key, value any) bool {
+		if _, ok := apps[key.(Key)]; !ok {
+			log.WithField("key", key).
+				Info("dropping model of removed app")
+			a.models.Delete(key)
+			a.store.Delete(key.(Key))
+			delete(a.inits, key.(Key))
+		}
+		return true
+	})
+
+	appLib := a.appLib.withVMPool()
 	res := NewRegistry()
 	for key, app := range apps {
-		models.Store(key, app.Init)
-		model := &AppModel{
-			key:    key,
-			models: &models,
-			appLib: a.appLib,
+		model, emitInit, err := a.initialModel(key, app)
+		if err != nil {
+			return Registry{}, err
+		}
+		a.models.Store(key, model)
+		a.inits[key] = app.Init
+		if emitInit {
+			outputs, err := decodeOutputs(app.InitOutputs)
+			if err != nil {
+				return Registry{}, fmt.Errorf("failed to encode init outputs for app %s: %w", key, err)
+			}
+			res.InitOutputs = append(res.InitOutputs, outputs...)
+		}
+
+		for filter, codec := range app.Codecs {
+			if !validCodec(codec) {
+				return Registry{}, fmt.Errorf("unknown codec %q for subscription %s of app %s", codec, filter, key)
+			}
+		}
+
+		appModel := &AppModel{
+			key:           key,
+			subscriptions: app.Subscriptions,
+			codecs:        app.Codecs,
+			outputs:       app.Outputs,
+			dependsOn:     app.DependsOn,
+			init:          app.Init,
+			models:        a.models,
+			appLib:        appLib,
+			store:         a.store,
 		}
 		for _, topic := range app.Subscriptions {
-			res.TopicToModels[topic] = append(res.TopicToModels[topic], model)
+			res.TopicToModels[topic] = append(res.TopicToModels[topic], appModel)
 		}
-		res.KeyToModel[key] = model
+		res.KeyToModel[key] = appModel
 	}
 
 	return res, nil
 }
 
+// initialModel returns the model an app starts with after a (re)registration
+// and whether its init outputs should be published. They are published on
+// every process start, also for models restored from the store, and skipped
+// only when a hot reload keeps the model in memory. A model kept in memory
+// and a snapshot restored from the store go through the same shape check and
+// migration.
+func (a *AppRegistration) initialModel(key Key, app AppData) (any, bool, error) {
+	previous, ok := a.models.Load(key)
+	if ok {
+		return a.reconcile(key, app, previous, a.inits[key])
+	}
+
+	snapshot, ok, err := a.store.Load(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return app.Init, true, nil
+	}
+	model, _, err := a.reconcile(key, app, snapshot.Model, snapshot.Init)
+	if err != nil {
+		return nil, false, err
+	}
+	if !reflect.DeepEqual(snapshot.Init, app.Init) {
+		a.store.Save(key, Snapshot{Model: model, Init: app.Init})
+	}
+	return model, true, nil
+}
+
+// reconcile keeps previous if the shape of init did not change since it was
+// saved, and otherwise migrates it or resets it to init, which it reports.
+func (a *AppRegistration) reconcile(key Key, app AppData, previous any, previousInit any) (any, bool, error) {
+	if reflect.DeepEqual(shapeOf(previousInit), shapeOf(app.Init)) {
+		return previous, false, nil
+	}
+
+	if !app.Migrate {
+		log.WithField("key", key).
+			Info("model shape changed without migration, resetting model to init")
+		return app.Init, true, nil
+	}
+
+	log.WithField("key", key).
+		Info("model shape changed, migrating model")
+	migrated, err := a.appLib.migrate(key, previous)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to migrate model for app %s: %w", key, err)
+	}
+	return migrated, false, nil
+}
+
+func shapeOf(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		shape := make(map[string]any, len(value))
+		for key, child := range value {
+			shape[key] = shapeOf(child)
+		}
+		return shape
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
 type AppModel struct {
-	key    Key
-	models *sync.Map
-	appLib AppLib
+	key           Key
+	subscriptions []Topic
+	codecs        map[Topic]string
+	outputs       []Topic
+	dependsOn     map[string]Key
+	init          any
+	models        *sync.Map
+	appLib        AppLib
+	store         StateStore
 }
 
 func (a *AppModel) Key() string {
 	return a.key
 }
 
-func (a *AppModel) Update(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+func (a *AppModel) Update(ctx context.Context, filter Topic, topic Topic, payload Payload) ([]TopicPayload, error) {
 	model, ok := a.models.Load(a.key)
 	if !ok {
 		return nil, fmt.Errorf("model not found for app %s", a.key)
 	}
 
-	updates, err := a.appLib.update(a.key, topic, payload, model)
+	captures, ok := MatchTopic(filter, topic)
+	if !ok {
+		return nil, fmt.Errorf("subscription %s of app %s does not match topic %s", filter, a.key, topic)
+	}
+	if captures == nil {
+		captures = []string{}
+	}
+
+	decoded, err := decodePayload(a.codecs[filter], payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode payload of topic %s for app %s: %w", topic, a.key, err)
+	}
+
+	updates, err := a.appLib.update(a.key, filter, topic, captures, decoded, model, a.deps())
 	if err != nil {
 		return nil, err
 	}
 
+	outputs, err := decodeOutputs(updates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid outputs of app %s: %w", a.key, err)
+	}
+
 	if model, ok := updates["model"]; ok {
 		a.models.Store(a.key, model)
+		a.store.Save(a.key, Snapshot{Model: model, Init: a.init})
 	}
+	return outputs, nil
+}
 
-	outputs := make(map[Topic]Payload)
-	for topic, payload := range updates {
-		if topic == "model" {
+// decodeOutputs turns the result of an update or init into messages. Plain
+// topic fields come first, sorted by topic, followed by the envelopes of the
+// outputs list in their given order.
+func decodeOutputs(updates map[string]any) ([]TopicPayload, error) {
+	topics := make([]Topic, 0, len(updates))
+	for topic := range updates {
+		if topic == "model" || topic == "outputs" {
 			continue
 		}
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
 
-		b, err := json.Marshal(payload)
+	res := make([]TopicPayload, 0, len(topics))
+	for _, topic := range topics {
+		b, err := json.Marshal(updates[topic])
 		if err != nil {
 			return nil, err
 		}
-		outputs[topic] = string(b)
+		res = append(res, TopicPayload{
+			Topic:   topic,
+			Payload: string(b),
+		})
 	}
-	return outputs, nil
+
+	envelopes, ok := updates["outputs"]
+	if !ok {
+		return res, nil
+	}
+	list, ok := envelopes.([]any)
+	if !ok {
+		return nil, errors.New("outputs must be a list")
+	}
+	for i, envelope := range list {
+		tp, err := decodeEnvelope(envelope)
+		if err != nil {
+			return nil, fmt.Errorf("output %d: %w", i, err)
+		}
+		res = append(res, tp)
+	}
+	return res, nil
+}
+
+func decodeEnvelope(envelope any) (TopicPayload, error) {
+	fields, ok := envelope.(map[string]any)
+	if !ok {
+		return TopicPayload{}, errors.New("output must be an object")
+	}
+	topic, ok := fields["topic"].(string)
+	if !ok || topic == "" {
+		return TopicPayload{}, errors.New("output topic must be a non-empty string")
+	}
+	encoding, ok := fields["encoding"].(string)
+	if _, present := fields["encoding"]; present && !ok {
+		return TopicPayload{}, errors.New("output encoding must be a string")
+	}
+	payload, err := encodePayload(encoding, fields["payload"])
+	if err != nil {
+		return TopicPayload{}, err
+	}
+	tp := TopicPayload{
+		Topic:   topic,
+		Payload: payload,
+	}
+	if qos, ok := fields["qos"]; ok {
+		q, ok := qos.(float64)
+		if !ok || (q != 0 && q != 1 && q != 2) {
+			return TopicPayload{}, errors.New("output qos must be 0, 1 or 2")
+		}
+		tp.Qos = byte(q)
+	}
+	if retain, ok := fields["retain"]; ok {
+		r, ok := retain.(bool)
+		if !ok {
+			return TopicPayload{}, errors.New("output retain must be a boolean")
+		}
+		tp.Retain = r
+	}
+	return tp, nil
+}
+
+// deps reads the current models of the apps this app depends on, so that
+// updates see the latest state without mirroring it over topics.
+func (a *AppModel) deps() map[string]any {
+	deps := make(map[string]any, len(a.dependsOn))
+	for name, key := range a.dependsOn {
+		deps[name], _ = a.models.Load(key)
+	}
+	return deps
+}
+
+func (a *AppModel) Subscriptions() []Topic {
+	return a.subscriptions
+}
+
+func (a *AppModel) Outputs() []Topic {
+	return a.outputs
+}
+
+func (a *AppModel) State(ctx context.Context) (any, error) {
+	model, ok := a.models.Load(a.key)
+	if !ok {
+		return nil, fmt.Errorf("model not found for app %s", a.key)
+	}
+	return model, nil
 }
 
 func (a *AppModel) View(ctx context.Context) (string, error) {
@@ -110,11 +366,18 @@ func (a *AppModel) View(ctx context.Context) (string, error) {
 type AppLib struct {
 	config string
 	vendor []string
+	clock  Clock
+	vms    *vmPool
 }
 
 type AppData struct {
-	Init          any      `json:"init"`
-	Subscriptions []string `json:"subscriptions"`
+	Init          any              `json:"init"`
+	InitOutputs   map[string]any   `json:"initOutputs"`
+	Subscriptions []string         `json:"subscriptions"`
+	Codecs        map[Topic]string `json:"codecs"`
+	Outputs       []string         `json:"outputs"`
+	DependsOn     map[string]Key   `json:"dependsOn"`
+	Migrate       bool             `json:"migrate"`
 }
 
 //go:embed lib
@@ -122,15 +385,23 @@ var lib embed.FS
 
 func (a AppLib) vm() *jsonnet.VM {
 	vm := jsonnet.MakeVM()
-	vm.Importer(jsonnext.CompoundImporter{
+	vm.Importer(newCachingImporter(jsonnext.CompoundImporter{
 		Importers: []jsonnet.Importer{
 			&jsonnext.FSImporter{Fs: lib},
 			&jsonnet.FileImporter{JPaths: a.vendor},
 		},
-	})
+	}))
+	for _, f := range NativeFunctions(a.clock) {
+		vm.NativeFunction(f)
+	}
 	return vm
 }
 
+func (a AppLib) withVMPool() AppLib {
+	a.vms = newVMPool(runtime.GOMAXPROCS(0), a.vm)
+	return a
+}
+
 func (a AppLib) listApps() (map[string]AppData, error) {
 	vm := a.vm()
 	vm.TLACode("config", fmt.Sprintf("import '%s'", a.config))
@@ -146,17 +417,50 @@ func (a AppLib) listApps() (map[string]AppData, error) {
 	return apps, nil
 }
 
-func (a AppLib) update(key Key, topic Topic, payload Payload, model any) (map[string]any, error) {
+func (a AppLib) migrate(key Key, model any) (any, error) {
 	vm := a.vm()
 	vm.TLACode("config", fmt.Sprintf("import '%s'", a.config))
 	vm.TLAVar("key", key)
+	jsonModel, err := json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	vm.TLACode("model", string(jsonModel))
+	jsonStr, err := vm.EvaluateFile("./lib/migrate.libsonnet")
+	if err != nil {
+		return nil, err
+	}
+	var migrated any
+	err = json.Unmarshal([]byte(jsonStr), &migrated)
+	if err != nil {
+		return nil, err
+	}
+	return migrated, nil
+}
+
+func (a AppLib) update(key Key, filter Topic, topic Topic, captures []string, payload Payload, model any, deps map[string]any) (map[string]any, error) {
+	vm := a.vms.get()
+	defer a.vms.put(vm)
+	vm.TLACode("config", fmt.Sprintf("import '%s'", a.config))
+	vm.TLAVar("key", key)
+	vm.TLAVar("filter", filter)
 	vm.TLAVar("topic", topic)
+	jsonCaptures, err := json.Marshal(captures)
+	if err != nil {
+		return nil, err
+	}
+	vm.TLACode("captures", string(jsonCaptures))
 	vm.TLACode("payload", payload)
 	jsonModel, err := json.Marshal(model)
 	if err != nil {
 		return nil, err
 	}
 	vm.TLACode("model", string(jsonModel))
+	jsonDeps, err := json.Marshal(deps)
+	if err != nil {
+		return nil, err
+	}
+	vm.TLACode("deps", string(jsonDeps))
 	jsonStr, err := vm.EvaluateFile("./lib/update.libsonnet")
 	if err != nil {
 		return nil, err
@@ -170,7 +474,8 @@ func (a AppLib) update(key Key, topic Topic, payload Payload, model any) (map[st
 }
 
 func (a AppLib) view(key Key, model any, fragment bool) (string, error) {
-	vm := a.vm()
+	vm := a.vms.get()
+	defer a.vms.put(vm)
 	vm.TLACode("config", fmt.Sprintf("import '%s'", a.config))
 	vm.TLAVar("key", key)
 	vm.TLACode("fragment", fmt.Sprintf("%t", fragment))
@@ -1,83 +1,378 @@
 package run
 
 import (
+	"context"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type Broker interface {
-	Subscribe(topic Topic) (<-chan Payload, Unsubscribe)
-	SubscribeAll() (<-chan TopicPayload, Unsubscribe)
+	Subscribe(filter Topic, opts ...SubscribeOption) (<-chan TopicPayload, Unsubscribe)
+	SubscribeAll(opts ...SubscribeOption) (<-chan TopicPayload, Unsubscribe)
 	Publish(topic Topic, payload Payload)
+	PublishMessage(tp TopicPayload)
 }
 
 type Unsubscribe func()
 
+// Policy decides what happens to a message when a subscriber's buffer is
+// full.
+type Policy string
+
+const (
+	PolicyDropNewest Policy = "drop_newest"
+	PolicyDropOldest Policy = "drop_oldest"
+	PolicyBlock      Policy = "block"
+	PolicyUnbounded  Policy = "unbounded"
+)
+
+const (
+	DefaultBufferSize    = 16
+	DefaultBlockTimeout  = time.Second
+	DefaultStatsInterval = time.Minute
+)
+
+// BrokerConfig configures the subscriptions of the brokers. Dropped messages
+// are logged once every StatsInterval, and the HTTP plugin serves the counts
+// at /api/stats.
+type BrokerConfig struct {
+	BufferSize    int                `mapstructure:"buffer_size"`
+	Policy        Policy             `mapstructure:"policy"`
+	BlockTimeout  time.Duration      `mapstructure:"block_timeout"`
+	StatsInterval time.Duration      `mapstructure:"stats_interval"`
+	Interceptors  InterceptorsConfig `mapstructure:"interceptors"`
+}
+
+type SubscribeOption func(config *BrokerConfig)
+
+func WithPolicy(policy Policy) SubscribeOption {
+	return func(config *BrokerConfig) {
+		config.Policy = policy
+	}
+}
+
+func WithBufferSize(size int) SubscribeOption {
+	return func(config *BrokerConfig) {
+		config.BufferSize = size
+	}
+}
+
+func WithBlockTimeout(timeout time.Duration) SubscribeOption {
+	return func(config *BrokerConfig) {
+		config.BlockTimeout = timeout
+	}
+}
+
+// StatsBroker is implemented by brokers that count the messages their
+// subscriptions queue and drop.
+type StatsBroker interface {
+	Stats() []SubscriptionStats
+}
+
+type SubscriptionStats struct {
+	Filter  Topic  `json:"filter"`
+	Policy  Policy `json:"policy"`
+	Queued  int    `json:"queued"`
+	Dropped uint64 `json:"dropped"`
+}
+
+type subscription struct {
+	filter  Topic
+	config  BrokerConfig
+	ch      chan TopicPayload
+	queue   *mailbox[TopicPayload]
+	dropped atomic.Uint64
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// close stops deliveries to the subscription. Messages are delivered outside
+// the broker lock, so a delivery may still be in flight. Closing done
+// releases a blocked one, and close returns once it finished, after which ch
+// can be closed safely.
+func (s *subscription) close() {
+	close(s.done)
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+}
+
 type MutexBroker struct {
+	name   string
+	config BrokerConfig
+
 	mu      sync.RWMutex
-	topics  map[Topic]map[chan Payload]struct{}
-	allSubs map[chan TopicPayload]struct{}
+	topics  map[Topic]map[*subscription]struct{}
+	allSubs map[*subscription]struct{}
 }
 
-func NewBroker() *MutexBroker {
+func NewBroker(name string, config BrokerConfig) *MutexBroker {
 	return &MutexBroker{
-		topics:  make(map[Topic]map[chan Payload]struct{}),
-		allSubs: make(map[chan TopicPayload]struct{}),
+		name:    name,
+		config:  config,
+		topics:  make(map[Topic]map[*subscription]struct{}),
+		allSubs: make(map[*subscription]struct{}),
 	}
 }
 
-func (b *MutexBroker) Subscribe(topic Topic) (<-chan Payload, Unsubscribe) {
-	ch := make(chan Payload, 16)
+func (b *MutexBroker) Subscribe(filter Topic, opts ...SubscribeOption) (<-chan TopicPayload, Unsubscribe) {
+	sub, stop := b.newSubscription(filter, opts)
 
 	b.mu.Lock()
-	if _, ok := b.topics[topic]; !ok {
-		b.topics[topic] = make(map[chan Payload]struct{})
+	if _, ok := b.topics[filter]; !ok {
+		b.topics[filter] = make(map[*subscription]struct{})
 	}
-	b.topics[topic][ch] = struct{}{}
+	b.topics[filter][sub] = struct{}{}
 	b.mu.Unlock()
 
 	unsub := func() {
 		b.mu.Lock()
-		delete(b.topics[topic], ch)
-		if len(b.topics[topic]) == 0 {
-			delete(b.topics, topic)
+		delete(b.topics[filter], sub)
+		if len(b.topics[filter]) == 0 {
+			delete(b.topics, filter)
 		}
 		b.mu.Unlock()
-		close(ch)
+		stop()
 	}
 
-	return ch, unsub
+	return sub.ch, unsub
 }
 
-func (b *MutexBroker) SubscribeAll() (<-chan TopicPayload, Unsubscribe) {
-	ch := make(chan TopicPayload, 16)
+func (b *MutexBroker) SubscribeAll(opts ...SubscribeOption) (<-chan TopicPayload, Unsubscribe) {
+	sub, stop := b.newSubscription("#", opts)
 
 	b.mu.Lock()
-	b.allSubs[ch] = struct{}{}
+	b.allSubs[sub] = struct{}{}
 	b.mu.Unlock()
 
 	unsub := func() {
 		b.mu.Lock()
-		delete(b.allSubs, ch)
+		delete(b.allSubs, sub)
 		b.mu.Unlock()
-		close(ch)
+		stop()
+	}
+
+	return sub.ch, unsub
+}
+
+func (b *MutexBroker) newSubscription(filter Topic, opts []SubscribeOption) (*subscription, func()) {
+	config := b.config
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+	if config.Policy == "" {
+		config.Policy = PolicyDropNewest
+	}
+	if config.BlockTimeout <= 0 {
+		config.BlockTimeout = DefaultBlockTimeout
+	}
+
+	sub := &subscription{
+		filter: filter,
+		config: config,
+		ch:     make(chan TopicPayload, config.BufferSize),
+		done:   make(chan struct{}),
+	}
+	if config.Policy != PolicyUnbounded {
+		return sub, func() {
+			sub.close()
+			close(sub.ch)
+		}
 	}
 
-	return ch, unsub
+	// Unbounded subscriptions queue messages in a mailbox and forward them
+	// to the channel as the subscriber catches up.
+	sub.queue = newMailbox[TopicPayload]()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(sub.ch)
+		for {
+			tp, err := sub.queue.receive(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case sub.ch <- tp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return sub, func() {
+		sub.close()
+		cancel()
+		<-done
+	}
 }
 
 func (b *MutexBroker) Publish(topic Topic, payload Payload) {
+	b.PublishMessage(TopicPayload{Topic: topic, Payload: payload})
+}
+
+// PublishMessage keeps the metadata of tp. A message without an origin
+// starts a new chain and gets a fresh one.
+func (b *MutexBroker) PublishMessage(tp TopicPayload) {
+	if tp.Origin == "" {
+		origin, err := newUUID()
+		if err != nil {
+			log.WithError(err).
+				Error("failed to generate message origin")
+		}
+		tp.Origin = origin
+	}
+
+	// Subscribers are collected under the lock and served outside of it, so
+	// that a blocking subscriber holds up only this publisher and neither
+	// other publishers nor subscribing and unsubscribing.
+	for _, sub := range b.subscriptions(tp.Topic) {
+		b.deliver(sub, tp)
+	}
+}
+
+// subscriptions returns the subscriptions that receive topic.
+func (b *MutexBroker) subscriptions(topic Topic) []*subscription {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-	for ch := range b.topics[topic] {
+
+	var res []*subscription
+	for filter, subs := range b.topics {
+		if _, ok := MatchTopic(filter, topic); !ok {
+			continue
+		}
+		for sub := range subs {
+			res = append(res, sub)
+		}
+	}
+	for sub := range b.allSubs {
+		res = append(res, sub)
+	}
+	return res
+}
+
+func (b *MutexBroker) deliver(sub *subscription, tp TopicPayload) {
+	sub.mu.RLock()
+	defer sub.mu.RUnlock()
+	if sub.closed {
+		return
+	}
+
+	switch sub.config.Policy {
+	case PolicyUnbounded:
+		sub.queue.post(tp)
+	case PolicyDropOldest:
+		for {
+			select {
+			case sub.ch <- tp:
+				return
+			default:
+			}
+			select {
+			case <-sub.ch:
+				b.dropped(sub, tp)
+			default:
+			}
+		}
+	case PolicyBlock:
 		select {
-		case ch <- payload:
+		case sub.ch <- tp:
+			return
 		default:
 		}
-	}
-	for ch := range b.allSubs {
+		timer := time.NewTimer(sub.config.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case sub.ch <- tp:
+		case <-timer.C:
+			b.dropped(sub, tp)
+		case <-sub.done:
+		}
+	default:
 		select {
-		case ch <- TopicPayload{Topic: topic, Payload: payload}:
+		case sub.ch <- tp:
 		default:
+			b.dropped(sub, tp)
+		}
+	}
+}
+
+// dropped counts a lost message and logs the first drop and every
+// hundredth after it, so that a stuck subscriber does not flood the logs.
+func (b *MutexBroker) dropped(sub *subscription, tp TopicPayload) {
+	count := sub.dropped.Add(1)
+	if count%100 != 1 {
+		return
+	}
+	log.WithField("broker", b.name).
+		WithField("filter", sub.filter).
+		WithField("policy", sub.config.Policy).
+		WithField("topic", tp.Topic).
+		WithField("dropped", count).
+		Warn("dropped message for slow subscriber")
+}
+
+func (b *MutexBroker) Stats() []SubscriptionStats {
+	res := []SubscriptionStats{}
+	for _, sub := range b.allSubscriptions() {
+		queued := len(sub.ch)
+		if sub.queue != nil {
+			queued += sub.queue.len()
+		}
+		res = append(res, SubscriptionStats{
+			Filter:  sub.filter,
+			Policy:  sub.config.Policy,
+			Queued:  queued,
+			Dropped: sub.dropped.Load(),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Filter < res[j].Filter
+	})
+	return res
+}
+
+// logDropped logs every subscription that dropped messages since the counts
+// in reported, and returns the current counts.
+func (b *MutexBroker) logDropped(reported map[*subscription]uint64) map[*subscription]uint64 {
+	res := make(map[*subscription]uint64)
+	for _, sub := range b.allSubscriptions() {
+		dropped := sub.dropped.Load()
+		res[sub] = dropped
+		if dropped == reported[sub] {
+			continue
+		}
+		log.WithField("broker", b.name).
+			WithField("filter", sub.filter).
+			WithField("policy", sub.config.Policy).
+			WithField("dropped", dropped).
+			WithField("since_last", dropped-reported[sub]).
+			Warn("subscriber dropped messages")
+	}
+	return res
+}
+
+func (b *MutexBroker) allSubscriptions() []*subscription {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var res []*subscription
+	for _, subs := range b.topics {
+		for sub := range subs {
+			res = append(res, sub)
 		}
 	}
+	for sub := range b.allSubs {
+		res = append(res, sub)
+	}
+	return res
 }
@@ -0,0 +1,62 @@
+package run
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBrokerBlockingSubscriberHoldsOnlyItsPublisher(t *testing.T) {
+	broker := NewBroker("test", BrokerConfig{})
+	_, unsubscribeBlocked := broker.Subscribe("blocked", WithPolicy(PolicyBlock), WithBufferSize(1), WithBlockTimeout(time.Hour))
+
+	broker.Publish("blocked", "first")
+	published := make(chan struct{})
+	go func() {
+		broker.Publish("blocked", "second")
+		close(published)
+	}()
+
+	ch, unsubscribe := broker.Subscribe("other")
+	defer unsubscribe()
+	done := make(chan struct{})
+	go func() {
+		broker.Publish("other", "message")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected publishing to another topic not to wait for the blocked subscriber")
+	}
+	if tp := <-ch; tp.Payload != "message" {
+		t.Errorf("expected message, got %s", tp.Payload)
+	}
+
+	unsubscribeBlocked()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("expected unsubscribing to release the blocked publisher")
+	}
+}
+
+func TestBrokerLogDropped(t *testing.T) {
+	broker := NewBroker("test", BrokerConfig{})
+	_, unsubscribe := broker.Subscribe("topic", WithBufferSize(1))
+	defer unsubscribe()
+
+	broker.Publish("topic", "first")
+	broker.Publish("topic", "second")
+	broker.Publish("topic", "third")
+
+	reported := broker.logDropped(nil)
+	stats := broker.Stats()
+	if len(stats) != 1 || stats[0].Dropped != 2 {
+		t.Fatalf("expected 2 dropped messages, got %+v", stats)
+	}
+	for _, dropped := range reported {
+		if dropped != 2 {
+			t.Errorf("expected 2 reported drops, got %d", dropped)
+		}
+	}
+}
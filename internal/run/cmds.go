@@ -3,51 +3,207 @@ package run
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+type CommandsConfig struct {
+	Http HttpCommandConfig `mapstructure:"http"`
+	Exec ExecCommandConfig `mapstructure:"exec"`
+}
+
 type CommandRegistration struct {
+	config    CommandsConfig
+	scheduler *Scheduler
+	kv        *KVStore
+	timers    *Timers
+}
+
+func NewCommandRegistration(config CommandsConfig, scheduler *Scheduler, kv *KVStore) *CommandRegistration {
+	return &CommandRegistration{
+		config:    config,
+		scheduler: scheduler,
+		kv:        kv,
+		timers:    NewTimers(),
+	}
+}
+
+func (c CommandRegistration) Timers() *Timers {
+	return c.timers
 }
 
 func (c CommandRegistration) Register() (Registry, error) {
 	return Registry{
 		TopicToCommands: map[Topic][]Command{
-			"yokai/delay": {DelayCommand{}},
+			DelayCommandFilter:    {DelayCommand{timers: c.timers}},
+			ScheduleCommandFilter: {ScheduleCommand{scheduler: c.scheduler}},
+			"yokai/http":          {NewHttpCommand(c.config.Http)},
+			"yokai/exec":          {NewExecCommand(c.config.Exec)},
+			KVCommandFilter:       {KVCommand{kv: c.kv}},
 		},
 	}, nil
 }
 
+// DelayCommandFilter subscribes DelayCommand to yokai/delay and
+// yokai/delay/cancel at once.
+const DelayCommandFilter = "yokai/delay/#"
+
+// Timers tracks pending delays that carry an id, so that they can be
+// replaced by a newer delay with the same id or cancelled.
+type Timers struct {
+	mu      sync.Mutex
+	pending map[string]*pendingTimer
+}
+
+type pendingTimer struct {
+	cancel context.CancelFunc
+}
+
+func NewTimers() *Timers {
+	return &Timers{
+		pending: make(map[string]*pendingTimer),
+	}
+}
+
+// start registers a delay under id and cancels the one it replaces. The
+// returned function releases the id once the delay is over.
+func (t *Timers) start(ctx context.Context, id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	timer := &pendingTimer{cancel: cancel}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if existing, ok := t.pending[id]; ok {
+		existing.cancel()
+	}
+	t.pending[id] = timer
+
+	return ctx, func() {
+		cancel()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.pending[id] == timer {
+			delete(t.pending, id)
+		}
+	}
+}
+
+// Cancel cancels the delay pending under id.
+func (t *Timers) Cancel(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	existing, ok := t.pending[id]
+	if !ok {
+		return false
+	}
+	existing.cancel()
+	delete(t.pending, id)
+	return true
+}
+
+// Pending returns the ids of the delays that are currently waiting.
+func (t *Timers) Pending() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make([]string, 0, len(t.pending))
+	for id := range t.pending {
+		res = append(res, id)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// DelayCommand handles yokai/delay and yokai/delay/cancel through one
+// subscription. Delays are started and cancelled in the order they were
+// published, so that a cancel right after a delay always cancels it, and
+// only the wait runs concurrently.
 type DelayCommand struct {
+	timers *Timers
 }
 
 type Delay struct {
+	Id           string
 	Milliseconds int
 	Topic        Topic
 	Message      any
 }
 
 func (d DelayCommand) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	wait, err := d.Defer(ctx, topic, payload)
+	if err != nil || wait == nil {
+		return nil, err
+	}
+	return wait()
+}
+
+func (d DelayCommand) Defer(ctx context.Context, topic Topic, payload Payload) (func() (map[Topic]Payload, error), error) {
+	switch topic {
+	case "yokai/delay":
+	case "yokai/delay/cancel":
+		_, err := CancelDelayCommand(d).Command(ctx, topic, payload)
+		return nil, err
+	default:
+		return nil, fmt.Errorf("unknown delay command %s", topic)
+	}
+
 	var delay Delay
 	err := json.Unmarshal([]byte(payload), &delay)
 	if err != nil {
 		return nil, err
 	}
 
-	log.WithField("milliseconds", delay.Milliseconds).
-		Info("sleeping")
-	select {
-	case <-time.After(time.Duration(delay.Milliseconds) * time.Millisecond):
-	case <-ctx.Done():
-		return nil, ctx.Err()
+	done := func() {}
+	if delay.Id != "" {
+		ctx, done = d.timers.start(ctx, delay.Id)
 	}
 
-	message, err := json.Marshal(delay.Message)
+	return func() (map[Topic]Payload, error) {
+		defer done()
+
+		log.WithField("id", delay.Id).
+			WithField("milliseconds", delay.Milliseconds).
+			Info("sleeping")
+		select {
+		case <-time.After(time.Duration(delay.Milliseconds) * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		message, err := json.Marshal(delay.Message)
+		if err != nil {
+			return nil, err
+		}
+		return map[Topic]Payload{
+			delay.Topic: string(message),
+		}, nil
+	}, nil
+}
+
+type CancelDelayCommand struct {
+	timers *Timers
+}
+
+type CancelDelay struct {
+	Id string
+}
+
+func (c CancelDelayCommand) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	var cancel CancelDelay
+	err := json.Unmarshal([]byte(payload), &cancel)
 	if err != nil {
 		return nil, err
 	}
-	return map[Topic]Payload{
-		delay.Topic: string(message),
-	}, nil
+
+	if c.timers.Cancel(cancel.Id) {
+		log.WithField("id", cancel.Id).
+			Info("cancelled delay")
+	}
+	return nil, nil
 }
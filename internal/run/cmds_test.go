@@ -0,0 +1,99 @@
+package run
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestTimersReplace(t *testing.T) {
+	timers := NewTimers()
+
+	first, _ := timers.start(context.Background(), "light-off")
+	second, done := timers.start(context.Background(), "light-off")
+
+	if first.Err() == nil {
+		t.Error("expected the earlier delay to be replaced")
+	}
+	if second.Err() != nil {
+		t.Error("expected the later delay to keep running")
+	}
+	if pending := timers.Pending(); !slices.Equal(pending, []string{"light-off"}) {
+		t.Errorf("expected light-off to be pending, got %v", pending)
+	}
+
+	done()
+	if pending := timers.Pending(); len(pending) != 0 {
+		t.Errorf("expected no pending delays, got %v", pending)
+	}
+}
+
+func TestTimersReplacedDoneKeepsLater(t *testing.T) {
+	timers := NewTimers()
+
+	_, firstDone := timers.start(context.Background(), "light-off")
+	second, _ := timers.start(context.Background(), "light-off")
+
+	firstDone()
+	if second.Err() != nil {
+		t.Error("expected the later delay to keep running")
+	}
+	if pending := timers.Pending(); !slices.Equal(pending, []string{"light-off"}) {
+		t.Errorf("expected light-off to be pending, got %v", pending)
+	}
+}
+
+func TestTimersCancel(t *testing.T) {
+	timers := NewTimers()
+
+	ctx, _ := timers.start(context.Background(), "light-off")
+	if !timers.Cancel("light-off") {
+		t.Error("expected the delay to be cancelled")
+	}
+	if ctx.Err() == nil {
+		t.Error("expected the delay context to be cancelled")
+	}
+	if pending := timers.Pending(); len(pending) != 0 {
+		t.Errorf("expected no pending delays, got %v", pending)
+	}
+	if timers.Cancel("light-off") {
+		t.Error("expected nothing left to cancel")
+	}
+}
+
+func TestDelayCancelledRightAfterPublish(t *testing.T) {
+	registry, err := NewCommandRegistration(CommandsConfig{}, NewScheduler(time.Now), NewKVStore(KVConfig{})).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewBroker("source", BrokerConfig{})
+	sink := NewBroker("sink", BrokerConfig{})
+	fired, unsubscribe := source.Subscribe("fired", WithPolicy(PolicyUnbounded))
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g, gCtx := errgroup.WithContext(ctx)
+	NewCommanderPlugin(DefaultMaxHops, ErrorConfig{}).Start(gCtx, g, registry, source, nil, sink)
+
+	for range 200 {
+		sink.Publish("yokai/delay", `{"id":"light-off","milliseconds":20,"topic":"fired","message":{}}`)
+		sink.Publish("yokai/delay/cancel", `{"id":"light-off"}`)
+	}
+	sink.Publish("yokai/delay", `{"milliseconds":50,"topic":"fired","message":{"last":true}}`)
+
+	select {
+	case tp := <-fired:
+		if tp.Payload != `{"last":true}` {
+			t.Fatalf("expected every cancelled delay to stay silent, got %s", tp.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the last delay")
+	}
+
+	cancel()
+	_ = g.Wait()
+}
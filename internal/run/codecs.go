@@ -0,0 +1,125 @@
+package run
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+const (
+	CodecJson   = "json"
+	CodecText   = "text"
+	CodecBase64 = "base64"
+	CodecForm   = "form"
+)
+
+func validCodec(codec string) bool {
+	switch codec {
+	case "", CodecJson, CodecText, CodecBase64, CodecForm:
+		return true
+	default:
+		return false
+	}
+}
+
+// decodePayload turns an incoming payload into the JSON value that is handed
+// to the app.
+func decodePayload(codec string, payload Payload) (string, error) {
+	switch codec {
+	case "", CodecJson:
+		if !json.Valid([]byte(payload)) {
+			return "", errors.New("payload is not valid JSON")
+		}
+		return payload, nil
+	case CodecText:
+		b, err := json.Marshal(payload)
+		return string(b), err
+	case CodecBase64:
+		b, err := json.Marshal(base64.StdEncoding.EncodeToString([]byte(payload)))
+		return string(b), err
+	case CodecForm:
+		values, err := url.ParseQuery(payload)
+		if err != nil {
+			return "", fmt.Errorf("payload is not valid form data: %w", err)
+		}
+		form := make(map[string]any, len(values))
+		for key, value := range values {
+			if len(value) == 1 {
+				form[key] = value[0]
+			} else {
+				form[key] = value
+			}
+		}
+		b, err := json.Marshal(form)
+		return string(b), err
+	default:
+		return "", fmt.Errorf("unknown codec %q", codec)
+	}
+}
+
+// encodePayload turns a value returned by an app into an outgoing payload.
+func encodePayload(encoding string, value any) (Payload, error) {
+	switch encoding {
+	case "", CodecJson:
+		b, err := json.Marshal(value)
+		return string(b), err
+	case CodecText:
+		return textOf(value)
+	case CodecBase64:
+		s, ok := value.(string)
+		if !ok {
+			return "", errors.New("base64 payload must be a string")
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return "", fmt.Errorf("payload is not valid base64: %w", err)
+		}
+		return string(b), nil
+	case CodecForm:
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return "", errors.New("form payload must be an object")
+		}
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		values := url.Values{}
+		for _, key := range keys {
+			list, ok := fields[key].([]any)
+			if !ok {
+				list = []any{fields[key]}
+			}
+			for _, item := range list {
+				s, err := textOf(item)
+				if err != nil {
+					return "", fmt.Errorf("form field %s: %w", key, err)
+				}
+				values.Add(key, s)
+			}
+		}
+		return values.Encode(), nil
+	default:
+		return "", fmt.Errorf("unknown encoding %q", encoding)
+	}
+}
+
+func textOf(value any) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case nil:
+		return "", nil
+	default:
+		return "", errors.New("text payload must be a string, number, boolean or null")
+	}
+}
@@ -0,0 +1,174 @@
+package run
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultMaxHops is how often a chain of messages may pass through apps
+// before it is dropped as a runaway loop.
+const DefaultMaxHops = 10
+
+// CommanderErrorKey is the key under which commands dropped for exceeding
+// their hops are reported to the error topic.
+const CommanderErrorKey = ReservedKeyPrefix + "commander"
+
+// CommanderPlugin runs the commands that apps publish to the sink and
+// publishes their outputs to the source. Outputs continue the chain of the
+// command that produced them, one hop further, so that two apps that react to
+// each other's kv changes or http replies are dropped after maxHops like any
+// other loop. Outputs of deferred commands, such as delays, start a new chain
+// instead: they come after a wait the app asked for, and a delay that re-arms
+// itself on every round is a timer rather than a runaway loop.
+type CommanderPlugin struct {
+	maxHops int
+	errors  ErrorConfig
+}
+
+func NewCommanderPlugin(maxHops int, errors ErrorConfig) *CommanderPlugin {
+	if maxHops <= 0 {
+		maxHops = DefaultMaxHops
+	}
+	return &CommanderPlugin{
+		maxHops: maxHops,
+		errors:  errors,
+	}
+}
+
+func (c *CommanderPlugin) Start(ctx context.Context, g *errgroup.Group, registry Registry, source Broker, view Broker, sink Broker) {
+	var subscriptions []commandSubscription
+	for topic, commands := range registry.TopicToCommands {
+		ch, unsubscribe := sink.Subscribe(topic, WithPolicy(PolicyUnbounded))
+		subscriptions = append(subscriptions, commandSubscription{
+			topic:       topic,
+			commands:    commands,
+			ch:          ch,
+			unsubscribe: unsubscribe,
+		})
+	}
+	g.Go(func() error {
+		commanderCtx, commanderCancel := context.WithCancel(ctx)
+		defer commanderCancel()
+
+		err := runCommander(commanderCtx, c.maxHops, c.errors, subscriptions, source, sink)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return nil
+	})
+}
+
+type commandSubscription struct {
+	topic       Topic
+	commands    []Command
+	ch          <-chan TopicPayload
+	unsubscribe Unsubscribe
+}
+
+func runCommander(
+	ctx context.Context,
+	maxHops int,
+	errorConfig ErrorConfig,
+	subscriptions []commandSubscription,
+	source Broker,
+	sink Broker,
+) error {
+	for _, subscription := range subscriptions {
+		defer subscription.unsubscribe()
+	}
+
+	g, gCtx := errgroup.WithContext(ctx)
+
+	for _, subscription := range subscriptions {
+		commands := subscription.commands
+		ch := subscription.ch
+
+		g.Go(func() error {
+			for {
+				select {
+				case <-gCtx.Done():
+					return gCtx.Err()
+				case tp, ok := <-ch:
+					if !ok {
+						return nil
+					}
+
+					log.WithField("topic", tp.Topic).
+						WithField("payload", tp.Payload).
+						Info("received command from topic")
+
+					if tp.Hops >= maxHops {
+						log.WithField("topic", tp.Topic).
+							WithField("origin", tp.Origin).
+							WithField("hops", tp.Hops).
+							Warn("dropping command that exceeded max hops")
+						err := fmt.Errorf("command exceeded max hops of %d", maxHops)
+						ReportError(errorConfig, sink, CommanderErrorKey, "command", tp, err)
+						continue
+					}
+
+					for _, command := range commands {
+						switch command := command.(type) {
+						case DeferredCommand:
+							deferCommand(gCtx, g, command, tp, source)
+						case SequentialCommand:
+							runCommand(gCtx, command, tp, source)
+						default:
+							g.Go(func() error {
+								runCommand(gCtx, command, tp, source)
+								return nil
+							})
+						}
+					}
+				}
+			}
+		})
+	}
+
+	return g.Wait()
+}
+
+func runCommand(ctx context.Context, command Command, tp TopicPayload, source Broker) {
+	outputs, err := command.Command(ctx, tp.Topic, tp.Payload)
+	publishOutputs(tp, outputs, err, source, false)
+}
+
+func deferCommand(ctx context.Context, g *errgroup.Group, command DeferredCommand, tp TopicPayload, source Broker) {
+	wait, err := command.Defer(ctx, tp.Topic, tp.Payload)
+	if err != nil || wait == nil {
+		publishOutputs(tp, nil, err, source, false)
+		return
+	}
+	g.Go(func() error {
+		outputs, err := wait()
+		publishOutputs(tp, outputs, err, source, true)
+		return nil
+	})
+}
+
+func publishOutputs(tp TopicPayload, outputs map[Topic]Payload, err error, source Broker, newChain bool) {
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			log.WithError(err).
+				WithField("topic", tp.Topic).
+				WithField("payload", tp.Payload).
+				Error("failed to run command")
+		}
+		return
+	}
+	for topic, payload := range outputs {
+		log.WithField("topic", topic).
+			WithField("payload", payload).
+			Info("publishing command output to topic")
+		output := TopicPayload{Topic: topic, Payload: payload}
+		if !newChain {
+			output.Origin = tp.Origin
+			output.Hops = tp.Hops + 1
+		}
+		source.PublishMessage(output)
+	}
+}
@@ -0,0 +1,102 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+type echoCommand struct{}
+
+func (e echoCommand) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	return map[Topic]Payload{"reply": payload}, nil
+}
+
+func startCommander(t *testing.T, registry Registry, errorConfig ErrorConfig) (Broker, Broker) {
+	source := NewBroker("source", BrokerConfig{})
+	sink := NewBroker("sink", BrokerConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, gCtx := errgroup.WithContext(ctx)
+	NewCommanderPlugin(3, errorConfig).Start(gCtx, g, registry, source, nil, sink)
+	t.Cleanup(func() {
+		cancel()
+		_ = g.Wait()
+	})
+	return source, sink
+}
+
+func receive(t *testing.T, ch <-chan TopicPayload) TopicPayload {
+	t.Helper()
+	select {
+	case tp := <-ch:
+		return tp
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for message")
+		return TopicPayload{}
+	}
+}
+
+func TestCommandOutputsContinueChain(t *testing.T) {
+	registry := Registry{TopicToCommands: map[Topic][]Command{"echo": {echoCommand{}}}}
+	source, sink := startCommander(t, registry, ErrorConfig{})
+	replies, unsubscribe := source.Subscribe("reply", WithPolicy(PolicyUnbounded))
+	defer unsubscribe()
+
+	sink.PublishMessage(TopicPayload{Topic: "echo", Payload: "{}", Origin: "chain", Hops: 2})
+
+	tp := receive(t, replies)
+	if tp.Origin != "chain" || tp.Hops != 3 {
+		t.Errorf("expected origin chain at 3 hops, got %s at %d hops", tp.Origin, tp.Hops)
+	}
+}
+
+func TestCommandExceedingMaxHopsIsDropped(t *testing.T) {
+	registry := Registry{TopicToCommands: map[Topic][]Command{"echo": {echoCommand{}}}}
+	source, sink := startCommander(t, registry, ErrorConfig{Topic: DefaultErrorTopic})
+	replies, unsubscribeReplies := source.Subscribe("reply", WithPolicy(PolicyUnbounded))
+	defer unsubscribeReplies()
+	errs, unsubscribeErrors := sink.Subscribe(DefaultErrorTopic+"/"+CommanderErrorKey, WithPolicy(PolicyUnbounded))
+	defer unsubscribeErrors()
+
+	sink.PublishMessage(TopicPayload{Topic: "echo", Payload: "{}", Origin: "chain", Hops: 3})
+
+	var appError AppError
+	err := json.Unmarshal([]byte(receive(t, errs).Payload), &appError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if appError.Topic != "echo" || appError.Origin != "chain" || appError.Hops != 3 {
+		t.Errorf("expected the dropped command to be reported, got %+v", appError)
+	}
+	select {
+	case tp := <-replies:
+		t.Errorf("expected no output, got %s", tp.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDelayOutputsStartNewChain(t *testing.T) {
+	registry, err := NewCommandRegistration(CommandsConfig{}, NewScheduler(time.Now), NewKVStore(KVConfig{})).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, sink := startCommander(t, registry, ErrorConfig{})
+	fired, unsubscribe := source.Subscribe("fired", WithPolicy(PolicyUnbounded))
+	defer unsubscribe()
+
+	sink.PublishMessage(TopicPayload{
+		Topic:   "yokai/delay",
+		Payload: `{"milliseconds":1,"topic":"fired","message":{}}`,
+		Origin:  "chain",
+		Hops:    2,
+	})
+
+	tp := receive(t, fired)
+	if tp.Origin == "chain" || tp.Hops != 0 {
+		t.Errorf("expected a new chain, got %s at %d hops", tp.Origin, tp.Hops)
+	}
+}
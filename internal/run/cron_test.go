@@ -0,0 +1,281 @@
+package run
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		name   string
+		expr   string
+		minute []int
+		hour   []int
+		dom    []int
+		month  []int
+		dow    []int
+		domAny bool
+		dowAny bool
+	}{
+		{
+			name:   "values",
+			expr:   "30 6 1 2 3",
+			minute: []int{30}, hour: []int{6}, dom: []int{1}, month: []int{2}, dow: []int{3},
+		},
+		{
+			name:   "wildcards",
+			expr:   "0 0 * * ?",
+			minute: []int{0}, hour: []int{0}, dom: rangeOf(1, 31), month: rangeOf(1, 12), dow: rangeOf(0, 7),
+			domAny: true, dowAny: true,
+		},
+		{
+			name:   "ranges and lists",
+			expr:   "0,30 8-10 1-3,15 * 1-5",
+			minute: []int{0, 30}, hour: []int{8, 9, 10}, dom: []int{1, 2, 3, 15}, month: rangeOf(1, 12), dow: []int{1, 2, 3, 4, 5},
+		},
+		{
+			name:   "steps",
+			expr:   "*/15 1-10/3 5/10 * *",
+			minute: []int{0, 15, 30, 45}, hour: []int{1, 4, 7, 10}, dom: []int{5, 15, 25}, month: rangeOf(1, 12), dow: rangeOf(0, 7),
+			dowAny: true,
+		},
+		{
+			name:   "names",
+			expr:   "0 0 * JAN-mar,dec Mon-Fri",
+			minute: []int{0}, hour: []int{0}, dom: rangeOf(1, 31), month: []int{1, 2, 3, 12}, dow: []int{1, 2, 3, 4, 5},
+			domAny: true,
+		},
+		{
+			name:   "dow 7 is sunday",
+			expr:   "0 0 * * 7",
+			minute: []int{0}, hour: []int{0}, dom: rangeOf(1, 31), month: rangeOf(1, 12), dow: []int{0, 7},
+			domAny: true,
+		},
+		{
+			name:   "weekend range up to 7",
+			expr:   "0 0 * * 6-7",
+			minute: []int{0}, hour: []int{0}, dom: rangeOf(1, 31), month: rangeOf(1, 12), dow: []int{0, 6, 7},
+			domAny: true,
+		},
+		{
+			name:   "dom and dow",
+			expr:   "0 0 13 * fri",
+			minute: []int{0}, hour: []int{0}, dom: []int{13}, month: rangeOf(1, 12), dow: []int{5},
+		},
+		{
+			name:   "descriptor",
+			expr:   "@Weekly",
+			minute: []int{0}, hour: []int{0}, dom: rangeOf(1, 31), month: rangeOf(1, 12), dow: []int{0},
+			domAny: true,
+		},
+		{
+			name:   "surrounding whitespace",
+			expr:   "  @hourly ",
+			minute: []int{0}, hour: rangeOf(0, 23), dom: rangeOf(1, 31), month: rangeOf(1, 12), dow: rangeOf(0, 7),
+			domAny: true, dowAny: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cron, err := parseCron(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := cronSchedule{
+				minute: bitsOf(test.minute),
+				hour:   bitsOf(test.hour),
+				dom:    bitsOf(test.dom),
+				month:  bitsOf(test.month),
+				dow:    bitsOf(test.dow),
+				domAny: test.domAny,
+				dowAny: test.dowAny,
+			}
+			if *cron != expected {
+				t.Errorf("expected %+v, got %+v", expected, *cron)
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@reboot",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"x * * * *",
+		"* * * foo *",
+		"5-1 * * * *",
+		"1-2-3 * * * *",
+		"1,,2 * * * *",
+		"*/0 * * * *",
+		"*/-1 * * * *",
+		"*/x * * * *",
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			_, err := parseCron(expr)
+			if err == nil {
+				t.Errorf("expected %q to be rejected", expr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		from     time.Time
+		expected time.Time
+	}{
+		{
+			name:     "later today",
+			expr:     "30 6 * * *",
+			from:     time.Date(2024, 6, 3, 5, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 6, 3, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "strictly after",
+			expr:     "30 6 * * *",
+			from:     time.Date(2024, 6, 3, 6, 30, 0, 0, time.UTC),
+			expected: time.Date(2024, 6, 4, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "seconds are dropped",
+			expr:     "* * * * *",
+			from:     time.Date(2024, 6, 3, 6, 30, 59, 999, time.UTC),
+			expected: time.Date(2024, 6, 3, 6, 31, 0, 0, time.UTC),
+		},
+		{
+			name:     "weekdays skip the weekend",
+			expr:     "30 6 * * mon-fri",
+			from:     time.Date(2024, 6, 7, 7, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 6, 10, 6, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "dow 7 is sunday",
+			expr:     "0 12 * * 7",
+			from:     time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 6, 9, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "dom or dow",
+			expr:     "0 0 13 * fri",
+			from:     time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 9, 6, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "dom or dow matches dom",
+			expr:     "0 0 13 * fri",
+			from:     time.Date(2024, 9, 7, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 9, 13, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "dom with any dow",
+			expr:     "0 0 13 * *",
+			from:     time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 9, 13, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "month end",
+			expr:     "0 0 1 * *",
+			from:     time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "short months are skipped",
+			expr:     "0 0 31 * *",
+			from:     time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "leap day",
+			expr:     "0 0 29 2 *",
+			from:     time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "year end",
+			expr:     "@daily",
+			from:     time.Date(2024, 12, 31, 23, 59, 30, 0, time.UTC),
+			expected: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "never",
+			expr:     "0 0 30 2 *",
+			from:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			expected: time.Time{},
+		},
+		{
+			name:     "location",
+			expr:     "30 6 * * *",
+			from:     time.Date(2024, 6, 3, 5, 0, 0, 0, berlin),
+			expected: time.Date(2024, 6, 3, 4, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "time skipped by spring forward",
+			expr:     "30 2 * * *",
+			from:     time.Date(2024, 3, 30, 3, 0, 0, 0, berlin),
+			expected: time.Date(2024, 4, 1, 0, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "hourly across spring forward",
+			expr:     "0 * * * *",
+			from:     time.Date(2024, 3, 31, 1, 30, 0, 0, berlin),
+			expected: time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "time repeated by fall back fires once",
+			expr:     "30 2 * * *",
+			from:     time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC).In(berlin),
+			expected: time.Date(2024, 10, 28, 1, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "steps pause through the repeated hour",
+			expr:     "*/15 * * * *",
+			from:     time.Date(2024, 10, 27, 0, 50, 0, 0, time.UTC).In(berlin),
+			expected: time.Date(2024, 10, 27, 2, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cron, err := parseCron(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual := cron.next(test.from)
+			if !actual.Equal(test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func rangeOf(min int, max int) []int {
+	var res []int
+	for v := min; v <= max; v++ {
+		res = append(res, v)
+	}
+	return res
+}
+
+func bitsOf(values []int) uint64 {
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
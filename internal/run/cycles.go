@@ -0,0 +1,125 @@
+package run
+
+import (
+	"sort"
+)
+
+// TopicCycle is a group of apps that can reach each other through their
+// declared outputs and subscriptions.
+type TopicCycle struct {
+	Keys   []Key
+	Topics []Topic
+}
+
+// findCycles returns the strongly connected components of the app graph,
+// where an edge leads from an app to every app subscribed to one of its
+// declared outputs.
+func findCycles(apps map[Key]AppData) []TopicCycle {
+	keys := make([]Key, 0, len(apps))
+	for key := range apps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	edges := make(map[Key][]Key)
+	edgeTopics := make(map[[2]Key][]Topic)
+	for _, from := range keys {
+		for _, to := range keys {
+			for _, output := range apps[from].Outputs {
+				for _, subscription := range apps[to].Subscriptions {
+					if !FiltersOverlap(output, subscription) {
+						continue
+					}
+					edge := [2]Key{from, to}
+					if _, ok := edgeTopics[edge]; !ok {
+						edges[from] = append(edges[from], to)
+					}
+					edgeTopics[edge] = append(edgeTopics[edge], output)
+				}
+			}
+		}
+	}
+
+	var cycles []TopicCycle
+	for _, component := range stronglyConnected(keys, edges) {
+		if len(component) == 1 {
+			key := component[0]
+			if _, ok := edgeTopics[[2]Key{key, key}]; !ok {
+				continue
+			}
+		}
+		sort.Strings(component)
+
+		seen := make(map[Topic]bool)
+		var topics []Topic
+		for _, from := range component {
+			for _, to := range component {
+				for _, topic := range edgeTopics[[2]Key{from, to}] {
+					if !seen[topic] {
+						seen[topic] = true
+						topics = append(topics, topic)
+					}
+				}
+			}
+		}
+		sort.Strings(topics)
+		cycles = append(cycles, TopicCycle{
+			Keys:   component,
+			Topics: topics,
+		})
+	}
+	sort.Slice(cycles, func(i, j int) bool {
+		return cycles[i].Keys[0] < cycles[j].Keys[0]
+	})
+	return cycles
+}
+
+// stronglyConnected implements Tarjan's algorithm.
+func stronglyConnected(keys []Key, edges map[Key][]Key) [][]Key {
+	index := 0
+	indices := make(map[Key]int)
+	lowlinks := make(map[Key]int)
+	onStack := make(map[Key]bool)
+	var stack []Key
+	var res [][]Key
+
+	var visit func(key Key)
+	visit = func(key Key) {
+		indices[key] = index
+		lowlinks[key] = index
+		index++
+		stack = append(stack, key)
+		onStack[key] = true
+
+		for _, next := range edges[key] {
+			if _, ok := indices[next]; !ok {
+				visit(next)
+				lowlinks[key] = min(lowlinks[key], lowlinks[next])
+			} else if onStack[next] {
+				lowlinks[key] = min(lowlinks[key], indices[next])
+			}
+		}
+
+		if lowlinks[key] != indices[key] {
+			return
+		}
+		var component []Key
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == key {
+				break
+			}
+		}
+		res = append(res, component)
+	}
+
+	for _, key := range keys {
+		if _, ok := indices[key]; !ok {
+			visit(key)
+		}
+	}
+	return res
+}
@@ -0,0 +1,96 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const DefaultErrorTopic = "yokai/errors"
+
+// ErrorConfig configures where update and view failures are published.
+// Failures of an app are published to <topic>/<app-key>, an empty topic
+// disables publishing.
+type ErrorConfig struct {
+	Topic Topic `mapstructure:"topic"`
+
+	clock Clock
+}
+
+// WithClock sets the clock for error timestamps, so that they agree with
+// the time apps see.
+func (c ErrorConfig) WithClock(clock Clock) ErrorConfig {
+	c.clock = clock
+	return c
+}
+
+type AppError struct {
+	Key       Key             `json:"key"`
+	Stage     string          `json:"stage"`
+	Topic     Topic           `json:"topic"`
+	Payload   json.RawMessage `json:"payload"`
+	Origin    string          `json:"origin"`
+	Hops      int             `json:"hops"`
+	Error     string          `json:"error"`
+	Stack     []string        `json:"stack"`
+	Timestamp string          `json:"timestamp"`
+}
+
+func (c ErrorConfig) topic(key Key) Topic {
+	return fmt.Sprintf("%s/%s", c.Topic, key)
+}
+
+func (c ErrorConfig) isErrorTopic(topic Topic) bool {
+	return c.Topic != "" && strings.HasPrefix(topic, c.Topic+"/")
+}
+
+// ReportError publishes a failure to the error topic of the app on the sink,
+// where plugins pick it up like any other output and the loop plugin routes
+// it back to apps that subscribe to it. Failures while handling an error
+// topic are only logged, so that a broken error handler cannot feed itself.
+func ReportError(config ErrorConfig, sink Broker, key Key, stage string, tp TopicPayload, err error) {
+	if config.Topic == "" || config.isErrorTopic(tp.Topic) {
+		return
+	}
+
+	appError := AppError{
+		Key:       key,
+		Stage:     stage,
+		Topic:     tp.Topic,
+		Payload:   rawPayload(tp.Payload),
+		Origin:    tp.Origin,
+		Hops:      tp.Hops,
+		Stack:     []string{},
+		Timestamp: config.clock.now().UTC().Format(time.RFC3339Nano),
+	}
+	// Jsonnet errors arrive formatted, with the message on the first line
+	// followed by one line per stack frame.
+	lines := strings.Split(strings.TrimSpace(err.Error()), "\n")
+	appError.Error = lines[0]
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			appError.Stack = append(appError.Stack, line)
+		}
+	}
+
+	b, err := json.Marshal(appError)
+	if err != nil {
+		log.WithError(err).
+			WithField("key", key).
+			Error("failed to marshal error")
+		return
+	}
+	sink.Publish(config.topic(key), string(b))
+}
+
+func rawPayload(payload Payload) json.RawMessage {
+	if json.Valid([]byte(payload)) {
+		return json.RawMessage(payload)
+	}
+	b, _ := json.Marshal(payload)
+	return b
+}
@@ -0,0 +1,205 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultExecTimeout       = 30 * time.Second
+	DefaultExecMaxOutputSize = 64 * 1024
+)
+
+type ExecCommandConfig struct {
+	// Allowed maps the names apps use to the programs they may run. Nothing
+	// can be run by default.
+	Allowed       map[string]AllowedExec `mapstructure:"allowed"`
+	Timeout       time.Duration          `mapstructure:"timeout"`
+	MaxOutputSize int                    `mapstructure:"max_output_size"`
+}
+
+// AllowedExec is a program that apps may run. Args are text/template
+// templates executed with the params of the request, and each of them is
+// passed to the program as a single argument, without a shell. Programs only
+// see PATH and the given Env.
+type AllowedExec struct {
+	Command string        `mapstructure:"command"`
+	Args    []string      `mapstructure:"args"`
+	Dir     string        `mapstructure:"dir"`
+	Env     []string      `mapstructure:"env"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type ExecRequest struct {
+	Id     string
+	Name   string
+	Params map[string]any
+	Topic  Topic
+}
+
+// ExecResult is published to the reply topic of a request. A program that
+// could not be started or timed out has an exit code of -1 and an error.
+type ExecResult struct {
+	Id        string `json:"id,omitempty"`
+	Name      string `json:"name"`
+	ExitCode  int    `json:"exitCode"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type ExecCommand struct {
+	config ExecCommandConfig
+}
+
+func NewExecCommand(config ExecCommandConfig) ExecCommand {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultExecTimeout
+	}
+	if config.MaxOutputSize <= 0 {
+		config.MaxOutputSize = DefaultExecMaxOutputSize
+	}
+	return ExecCommand{config: config}
+}
+
+func (e ExecCommand) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	var request ExecRequest
+	err := json.Unmarshal([]byte(payload), &request)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := e.run(ctx, request)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		log.WithError(err).
+			WithField("id", request.Id).
+			WithField("name", request.Name).
+			Error("exec failed")
+		result.Id = request.Id
+		result.Name = request.Name
+		result.ExitCode = -1
+		result.Error = err.Error()
+	}
+	if request.Topic == "" {
+		return nil, nil
+	}
+
+	message, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return map[Topic]Payload{
+		request.Topic: string(message),
+	}, nil
+}
+
+func (e ExecCommand) run(ctx context.Context, request ExecRequest) (ExecResult, error) {
+	allowed, ok := e.config.Allowed[strings.ToLower(request.Name)]
+	if !ok {
+		return ExecResult{}, fmt.Errorf("command %q is not allowed", request.Name)
+	}
+	args, err := execArgs(allowed.Args, request.Params)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	timeout := e.config.Timeout
+	if allowed.Timeout > 0 {
+		timeout = allowed.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: e.config.MaxOutputSize}
+	stderr := &limitedBuffer{limit: e.config.MaxOutputSize}
+	cmd := exec.CommandContext(ctx, allowed.Command, args...)
+	cmd.Dir = allowed.Dir
+	cmd.Env = append([]string{"PATH=" + os.Getenv("PATH")}, allowed.Env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	log.WithField("id", request.Id).
+		WithField("name", request.Name).
+		WithField("command", allowed.Command).
+		WithField("args", args).
+		Info("running command")
+	err = cmd.Run()
+
+	result := ExecResult{
+		Id:        request.Id,
+		Name:      request.Name,
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return result, fmt.Errorf("command timed out after %s", timeout)
+	case ctx.Err() != nil:
+		return result, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	return result, err
+}
+
+func execArgs(templates []string, params map[string]any) ([]string, error) {
+	args := make([]string, 0, len(templates))
+	for i, text := range templates {
+		tmpl, err := template.New(fmt.Sprintf("arg %d", i)).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		var b strings.Builder
+		err = tmpl.Execute(&b, params)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, b.String())
+	}
+	return args, nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest, so that a chatty program cannot exhaust memory.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := l.limit - l.buf.Len()
+	if len(p) > remaining {
+		l.truncated = true
+		l.buf.Write(p[:max(remaining, 0)])
+		return len(p), nil
+	}
+	return l.buf.Write(p)
+}
+
+func (l *limitedBuffer) String() string {
+	return l.buf.String()
+}
@@ -0,0 +1,168 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runExecCommand(t *testing.T, command ExecCommand, request map[string]any) ExecResult {
+	t.Helper()
+	request["topic"] = "reply"
+	payload, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := command.Command(context.Background(), "yokai/exec", string(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result ExecResult
+	err = json.Unmarshal([]byte(outputs["reply"]), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestExecCommandTemplatesArgs(t *testing.T) {
+	command := NewExecCommand(ExecCommandConfig{
+		Allowed: map[string]AllowedExec{
+			"args": {Command: "sh", Args: []string{"-c", `printf '%s|' "$@"`, "sh", "{{.room}}", "level {{.level}}"}},
+		},
+	})
+
+	result := runExecCommand(t, command, map[string]any{
+		"id":     "dim",
+		"name":   "Args",
+		"params": map[string]any{"room": "kitchen; echo injected", "level": 3},
+	})
+
+	if result.Id != "dim" || result.Name != "Args" || result.ExitCode != 0 || result.Error != "" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if result.Stdout != "kitchen; echo injected|level 3|" {
+		t.Errorf("expected each param to be passed as one argument, got %q", result.Stdout)
+	}
+}
+
+func TestExecCommandRejectsMissingParams(t *testing.T) {
+	command := NewExecCommand(ExecCommandConfig{
+		Allowed: map[string]AllowedExec{
+			"echo": {Command: "echo", Args: []string{"{{.room}}"}},
+		},
+	})
+
+	result := runExecCommand(t, command, map[string]any{
+		"name":   "echo",
+		"params": map[string]any{"rooom": "kitchen"},
+	})
+
+	if result.ExitCode != -1 || !strings.Contains(result.Error, `map has no entry for key "room"`) {
+		t.Errorf("expected a missing param to fail, got %+v", result)
+	}
+}
+
+func TestExecCommandRejectsNotAllowed(t *testing.T) {
+	command := NewExecCommand(ExecCommandConfig{
+		Allowed: map[string]AllowedExec{
+			"echo": {Command: "echo"},
+		},
+	})
+
+	result := runExecCommand(t, command, map[string]any{"name": "sh"})
+
+	if result.ExitCode != -1 || result.Error != `command "sh" is not allowed` {
+		t.Errorf("expected the command not to be allowed, got %+v", result)
+	}
+}
+
+func TestExecCommandReportsExitCode(t *testing.T) {
+	command := NewExecCommand(ExecCommandConfig{
+		Allowed: map[string]AllowedExec{
+			"fail": {Command: "sh", Args: []string{"-c", "echo out; echo err >&2; exit 3"}},
+		},
+	})
+
+	result := runExecCommand(t, command, map[string]any{"name": "fail"})
+
+	if result.ExitCode != 3 || result.Error != "" {
+		t.Errorf("expected exit code 3 without an error, got %+v", result)
+	}
+	if result.Stdout != "out\n" || result.Stderr != "err\n" {
+		t.Errorf("expected stdout and stderr to be kept apart, got %q and %q", result.Stdout, result.Stderr)
+	}
+}
+
+func TestExecCommandKillsOnTimeout(t *testing.T) {
+	command := NewExecCommand(ExecCommandConfig{
+		Timeout: time.Minute,
+		Allowed: map[string]AllowedExec{
+			"sleep": {Command: "sh", Args: []string{"-c", "echo started; sleep 30"}, Timeout: 100 * time.Millisecond},
+		},
+	})
+
+	start := time.Now()
+	result := runExecCommand(t, command, map[string]any{"name": "sleep"})
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the program to be killed, it ran for %s", elapsed)
+	}
+	if result.ExitCode != -1 || result.Error != "command timed out after 100ms" {
+		t.Errorf("expected a timeout, got %+v", result)
+	}
+	if result.Stdout != "started\n" {
+		t.Errorf("expected the output before the timeout to be kept, got %q", result.Stdout)
+	}
+}
+
+func TestExecCommandTruncatesOutput(t *testing.T) {
+	command := NewExecCommand(ExecCommandConfig{
+		MaxOutputSize: 10,
+		Allowed: map[string]AllowedExec{
+			"chatty": {Command: "sh", Args: []string{"-c", "printf '%0100d' 0; printf short >&2"}},
+		},
+	})
+
+	result := runExecCommand(t, command, map[string]any{"name": "chatty"})
+
+	if result.Stdout != "0000000000" || !result.Truncated {
+		t.Errorf("expected stdout to be truncated to 10 bytes, got %q, truncated %v", result.Stdout, result.Truncated)
+	}
+	if result.Stderr != "short" {
+		t.Errorf("expected stderr to be kept, got %q", result.Stderr)
+	}
+}
+
+func TestExecCommandRestrictsEnv(t *testing.T) {
+	t.Setenv("YOKAI_TEST_SECRET", "secret")
+	command := NewExecCommand(ExecCommandConfig{
+		Allowed: map[string]AllowedExec{
+			"env": {Command: "env", Env: []string{"GREETING=hello"}},
+		},
+	})
+
+	result := runExecCommand(t, command, map[string]any{"name": "env"})
+
+	env := strings.Split(strings.TrimSpace(result.Stdout), "\n")
+	slices.Sort(env)
+	if len(env) != 2 || env[0] != "GREETING=hello" || !strings.HasPrefix(env[1], "PATH=") {
+		t.Errorf("expected only PATH and the configured env, got %v", env)
+	}
+}
+
+func TestExecCommandWithoutTopic(t *testing.T) {
+	command := NewExecCommand(ExecCommandConfig{
+		Allowed: map[string]AllowedExec{
+			"true": {Command: "true"},
+		},
+	})
+
+	outputs, err := command.Command(context.Background(), "yokai/exec", `{"name":"true"}`)
+	if err != nil || outputs != nil {
+		t.Errorf("expected no outputs without a reply topic, got %v, %v", outputs, err)
+	}
+}
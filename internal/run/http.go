@@ -0,0 +1,222 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultHttpTimeout     = 10 * time.Second
+	DefaultHttpMaxBodySize = 1 << 20
+)
+
+type HttpCommandConfig struct {
+	// AllowedHosts lists the hosts requests may be sent to, either exactly,
+	// with a port, or as a *.example.com wildcard. No host is allowed by
+	// default.
+	AllowedHosts []string      `mapstructure:"allowed_hosts"`
+	Timeout      time.Duration `mapstructure:"timeout"`
+	MaxBodySize  int64         `mapstructure:"max_body_size"`
+}
+
+type HttpRequest struct {
+	Id           string
+	Method       string
+	Url          string
+	Headers      map[string]string
+	Body         any
+	Milliseconds int
+	Topic        Topic
+}
+
+// HttpResponse is published to the reply topic of a request. Failed
+// requests have a status of 0 and an error.
+type HttpResponse struct {
+	Id      string            `json:"id,omitempty"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    any               `json:"body"`
+	Error   string            `json:"error,omitempty"`
+}
+
+type HttpCommand struct {
+	config HttpCommandConfig
+	client *http.Client
+}
+
+func NewHttpCommand(config HttpCommandConfig) HttpCommand {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultHttpTimeout
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = DefaultHttpMaxBodySize
+	}
+	command := HttpCommand{config: config}
+	command.client = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if !command.allowed(req.URL) {
+				return fmt.Errorf("redirect to host %s is not allowed", req.URL.Host)
+			}
+			return nil
+		},
+	}
+	return command
+}
+
+func (h HttpCommand) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	var request HttpRequest
+	err := json.Unmarshal([]byte(payload), &request)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := h.do(ctx, request)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		log.WithError(err).
+			WithField("id", request.Id).
+			WithField("url", request.Url).
+			Error("http request failed")
+		response = HttpResponse{Id: request.Id, Error: err.Error()}
+	}
+	if request.Topic == "" {
+		return nil, nil
+	}
+
+	message, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	return map[Topic]Payload{
+		request.Topic: string(message),
+	}, nil
+}
+
+func (h HttpCommand) do(ctx context.Context, request HttpRequest) (HttpResponse, error) {
+	u, err := url.Parse(request.Url)
+	if err != nil {
+		return HttpResponse{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return HttpResponse{}, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	if !h.allowed(u) {
+		return HttpResponse{}, fmt.Errorf("host %s is not allowed", u.Host)
+	}
+
+	method := strings.ToUpper(request.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	body, err := requestBody(request.Body)
+	if err != nil {
+		return HttpResponse{}, err
+	}
+
+	timeout := h.config.Timeout
+	if request.Milliseconds > 0 {
+		timeout = min(timeout, time.Duration(request.Milliseconds)*time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return HttpResponse{}, err
+	}
+	if _, ok := request.Body.(string); request.Body != nil && !ok {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range request.Headers {
+		req.Header.Set(name, value)
+	}
+
+	log.WithField("id", request.Id).
+		WithField("method", method).
+		WithField("url", u.String()).
+		Info("sending http request")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return HttpResponse{}, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, h.config.MaxBodySize+1))
+	if err != nil {
+		return HttpResponse{}, err
+	}
+	if int64(len(b)) > h.config.MaxBodySize {
+		return HttpResponse{}, fmt.Errorf("response body exceeds %d bytes", h.config.MaxBodySize)
+	}
+
+	headers := make(map[string]string, len(resp.Header))
+	for name := range resp.Header {
+		headers[strings.ToLower(name)] = resp.Header.Get(name)
+	}
+	return HttpResponse{
+		Id:      request.Id,
+		Status:  resp.StatusCode,
+		Headers: headers,
+		Body:    responseBody(resp.Header.Get("Content-Type"), b),
+	}, nil
+}
+
+func (h HttpCommand) allowed(u *url.URL) bool {
+	hostname := strings.ToLower(u.Hostname())
+	host := strings.ToLower(u.Host)
+	for _, allowed := range h.config.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if allowed == hostname || allowed == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok && strings.HasSuffix(hostname, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBody sends strings as they are and any other value as JSON.
+func requestBody(body any) (io.Reader, error) {
+	switch body := body.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.NewReader(body), nil
+	default:
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(b), nil
+	}
+}
+
+// responseBody decodes JSON responses, so that apps can use them directly,
+// and passes anything else on as a string.
+func responseBody(contentType string, b []byte) any {
+	if strings.Contains(contentType, "json") {
+		var value any
+		if json.Unmarshal(b, &value) == nil {
+			return value
+		}
+	}
+	return string(b)
+}
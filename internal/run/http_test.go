@@ -0,0 +1,193 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func allowedServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, HttpCommandConfig) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, HttpCommandConfig{AllowedHosts: []string{u.Host}}
+}
+
+func runHttpCommand(t *testing.T, command HttpCommand, request map[string]any) HttpResponse {
+	t.Helper()
+	request["topic"] = "reply"
+	payload, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs, err := command.Command(context.Background(), "yokai/http", string(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response HttpResponse
+	err = json.Unmarshal([]byte(outputs["reply"]), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestHttpCommandRelaysResponse(t *testing.T) {
+	server, config := allowedServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		if r.Header.Get("X-Token") != "secret" {
+			t.Errorf("expected the request header to be sent, got %q", r.Header.Get("X-Token"))
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected a JSON request body, got %q", r.Header.Get("Content-Type"))
+		}
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "42")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"on":%v}`, body["on"])
+	})
+
+	response := runHttpCommand(t, NewHttpCommand(config), map[string]any{
+		"id":      "switch",
+		"method":  "post",
+		"url":     server.URL + "/switch",
+		"headers": map[string]string{"X-Token": "secret"},
+		"body":    map[string]any{"on": true},
+	})
+
+	if response.Id != "switch" || response.Status != http.StatusCreated || response.Error != "" {
+		t.Errorf("unexpected response %+v", response)
+	}
+	if response.Headers["x-request-id"] != "42" {
+		t.Errorf("expected lower-cased response headers, got %v", response.Headers)
+	}
+	body, ok := response.Body.(map[string]any)
+	if !ok || body["on"] != true {
+		t.Errorf("expected the decoded JSON body, got %v", response.Body)
+	}
+}
+
+func TestHttpCommandRelaysTextBody(t *testing.T) {
+	server, config := allowedServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("not here"))
+	})
+
+	response := runHttpCommand(t, NewHttpCommand(config), map[string]any{"url": server.URL})
+
+	if response.Status != http.StatusNotFound || response.Body != "not here" {
+		t.Errorf("unexpected response %+v", response)
+	}
+}
+
+func TestHttpCommandTimeout(t *testing.T) {
+	server, config := allowedServer(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	start := time.Now()
+	response := runHttpCommand(t, NewHttpCommand(config), map[string]any{
+		"url":          server.URL,
+		"milliseconds": 50,
+	})
+
+	if response.Status != 0 || !strings.Contains(response.Error, "deadline exceeded") {
+		t.Errorf("expected a timeout error, got %+v", response)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the request to time out after 50ms, took %s", elapsed)
+	}
+}
+
+func TestHttpCommandMaxBodySize(t *testing.T) {
+	server, config := allowedServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", 11)))
+	})
+	config.MaxBodySize = 10
+
+	response := runHttpCommand(t, NewHttpCommand(config), map[string]any{"url": server.URL})
+
+	if response.Status != 0 || response.Error != "response body exceeds 10 bytes" {
+		t.Errorf("expected the body to be rejected, got %+v", response)
+	}
+}
+
+func TestHttpCommandAllowlist(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	response := runHttpCommand(t, NewHttpCommand(HttpCommandConfig{AllowedHosts: []string{"example.com"}}), map[string]any{
+		"url": server.URL,
+	})
+
+	if response.Status != 0 || !strings.Contains(response.Error, "is not allowed") {
+		t.Errorf("expected the host to be rejected, got %+v", response)
+	}
+	if requested {
+		t.Error("expected no request to a host that is not allowed")
+	}
+}
+
+func TestHttpCommandRedirectAllowlist(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected no request to a redirect target that is not allowed")
+	}))
+	defer target.Close()
+	server, config := allowedServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// 127.0.0.1 and localhost are different hosts to the allowlist.
+		http.Redirect(w, r, strings.Replace(target.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	})
+
+	response := runHttpCommand(t, NewHttpCommand(config), map[string]any{"url": server.URL})
+
+	if response.Status != 0 || !strings.Contains(response.Error, "redirect to host") {
+		t.Errorf("expected the redirect to be rejected, got %+v", response)
+	}
+}
+
+func TestHttpCommandAllowed(t *testing.T) {
+	command := NewHttpCommand(HttpCommandConfig{AllowedHosts: []string{"api.example.com", "localhost:8080", "*.home.arpa"}})
+	tests := []struct {
+		url     string
+		allowed bool
+	}{
+		{url: "https://api.example.com/v1", allowed: true},
+		{url: "https://API.example.com:8443/v1", allowed: true},
+		{url: "https://example.com/", allowed: false},
+		{url: "http://localhost:8080/", allowed: true},
+		{url: "http://localhost:9090/", allowed: false},
+		{url: "http://lights.home.arpa/", allowed: true},
+		{url: "http://home.arpa/", allowed: false},
+		{url: "http://evilhome.arpa/", allowed: false},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed := command.allowed(u); allowed != test.allowed {
+			t.Errorf("allowed(%s) = %v, expected %v", test.url, allowed, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Interceptor sees every message published to a broker. It may inspect or
+// rewrite the message before passing it on to next, delay the call to next,
+// or drop the message by not calling next at all.
+type Interceptor interface {
+	Intercept(tp TopicPayload, next func(TopicPayload))
+}
+
+type InterceptorFunc func(tp TopicPayload, next func(TopicPayload))
+
+func (f InterceptorFunc) Intercept(tp TopicPayload, next func(TopicPayload)) {
+	f(tp, next)
+}
+
+type InterceptorsConfig struct {
+	Source []InterceptorConfig `mapstructure:"source"`
+	View   []InterceptorConfig `mapstructure:"view"`
+	Sink   []InterceptorConfig `mapstructure:"sink"`
+}
+
+type InterceptorConfig struct {
+	Type   string   `mapstructure:"type"`
+	Filter Topic    `mapstructure:"filter"`
+	From   string   `mapstructure:"from"`
+	To     string   `mapstructure:"to"`
+	Fields []string `mapstructure:"fields"`
+}
+
+// InterceptedBroker runs published messages through a chain of interceptors
+// before handing them to the wrapped broker.
+type InterceptedBroker struct {
+	Broker
+	interceptors []Interceptor
+}
+
+func NewInterceptedBroker(broker Broker, interceptors ...Interceptor) *InterceptedBroker {
+	return &InterceptedBroker{
+		Broker:       broker,
+		interceptors: interceptors,
+	}
+}
+
+func (b *InterceptedBroker) Publish(topic Topic, payload Payload) {
+	b.PublishMessage(TopicPayload{Topic: topic, Payload: payload})
+}
+
+func (b *InterceptedBroker) PublishMessage(tp TopicPayload) {
+	b.next(0)(tp)
+}
+
+func (b *InterceptedBroker) next(i int) func(TopicPayload) {
+	if i == len(b.interceptors) {
+		return b.Broker.PublishMessage
+	}
+	return func(tp TopicPayload) {
+		b.interceptors[i].Intercept(tp, b.next(i+1))
+	}
+}
+
+// Stats returns the stats of the wrapped broker, if it keeps any.
+func (b *InterceptedBroker) Stats() []SubscriptionStats {
+	if broker, ok := b.Broker.(StatsBroker); ok {
+		return broker.Stats()
+	}
+	return []SubscriptionStats{}
+}
+
+func intercept(name string, broker Broker, configs []InterceptorConfig) (Broker, error) {
+	if len(configs) == 0 {
+		return broker, nil
+	}
+	interceptors := make([]Interceptor, 0, len(configs))
+	for i, config := range configs {
+		interceptor, err := newInterceptor(name, config)
+		if err != nil {
+			return nil, fmt.Errorf("interceptor %d of %s broker: %w", i, name, err)
+		}
+		interceptors = append(interceptors, interceptor)
+	}
+	return NewInterceptedBroker(broker, interceptors...), nil
+}
+
+func newInterceptor(name string, config InterceptorConfig) (Interceptor, error) {
+	filter := config.Filter
+	if filter == "" {
+		filter = "#"
+	}
+	switch config.Type {
+	case "log":
+		return LogInterceptor(name, filter), nil
+	case "drop":
+		return DropInterceptor(filter), nil
+	case "rename":
+		if config.From == "" {
+			return nil, fmt.Errorf("rename interceptor requires from")
+		}
+		return RenameInterceptor(config.From, config.To), nil
+	case "redact":
+		return RedactInterceptor(filter, config.Fields), nil
+	default:
+		return nil, fmt.Errorf("unknown interceptor type %q", config.Type)
+	}
+}
+
+func LogInterceptor(name string, filter Topic) Interceptor {
+	return InterceptorFunc(func(tp TopicPayload, next func(TopicPayload)) {
+		if _, ok := MatchTopic(filter, tp.Topic); ok {
+			log.WithField("broker", name).
+				WithField("topic", tp.Topic).
+				WithField("payload", tp.Payload).
+				WithField("origin", tp.Origin).
+				WithField("hops", tp.Hops).
+				Info("intercepted message")
+		}
+		next(tp)
+	})
+}
+
+func DropInterceptor(filter Topic) Interceptor {
+	return InterceptorFunc(func(tp TopicPayload, next func(TopicPayload)) {
+		if _, ok := MatchTopic(filter, tp.Topic); ok {
+			return
+		}
+		next(tp)
+	})
+}
+
+// RenameInterceptor replaces the leading topic levels from with to, so that
+// from "lights" renames "lights/kitchen" but not "lightsout". An empty to
+// strips the levels.
+func RenameInterceptor(from string, to string) Interceptor {
+	to = strings.TrimSuffix(to, "/")
+	return InterceptorFunc(func(tp TopicPayload, next func(TopicPayload)) {
+		if rest, ok := CutTopicPrefix(tp.Topic, from); ok {
+			if to == "" {
+				rest = strings.TrimPrefix(rest, "/")
+			}
+			tp.Topic = to + rest
+		}
+		next(tp)
+	})
+}
+
+const redacted = "[redacted]"
+
+// RedactInterceptor replaces the given fields of JSON payloads, at any depth.
+// Without fields, the whole payload is replaced.
+func RedactInterceptor(filter Topic, fields []string) Interceptor {
+	redact := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		redact[field] = true
+	}
+	return InterceptorFunc(func(tp TopicPayload, next func(TopicPayload)) {
+		if _, ok := MatchTopic(filter, tp.Topic); !ok {
+			next(tp)
+			return
+		}
+		if len(redact) == 0 {
+			tp.Payload = redacted
+			next(tp)
+			return
+		}
+		var value any
+		err := json.Unmarshal([]byte(tp.Payload), &value)
+		if err != nil {
+			next(tp)
+			return
+		}
+		b, err := json.Marshal(redactValue(value, redact))
+		if err == nil {
+			tp.Payload = string(b)
+		}
+		next(tp)
+	})
+}
+
+func redactValue(value any, fields map[string]bool) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, child := range value {
+			if fields[key] {
+				value[key] = redacted
+			} else {
+				value[key] = redactValue(child, fields)
+			}
+		}
+		return value
+	case []any:
+		for i, child := range value {
+			value[i] = redactValue(child, fields)
+		}
+		return value
+	default:
+		return value
+	}
+}
@@ -0,0 +1,290 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	KVCommandFilter = "yokai/kv/+"
+	KVChangedTopic  = "yokai/kv/changed"
+)
+
+type KVConfig struct {
+	Persist bool   `mapstructure:"persist"`
+	Path    string `mapstructure:"path"`
+}
+
+// KVStore holds values shared by all apps. Like the Scheduler, it lives
+// outside a single run, so that values survive hot reloads. Persisted stores
+// write the file on every change.
+type KVStore struct {
+	config KVConfig
+
+	mu     sync.Mutex
+	loaded bool
+	values map[string]any
+}
+
+func NewKVStore(config KVConfig) *KVStore {
+	return &KVStore{
+		config: config,
+		values: make(map[string]any),
+	}
+}
+
+func (k *KVStore) Get(key string) (any, bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	err := k.read()
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := k.values[key]
+	return value, ok, nil
+}
+
+// Set stores value under key and reports whether it changed.
+func (k *KVStore) Set(key string, value any) (bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	err := k.read()
+	if err != nil {
+		return false, err
+	}
+	if existing, ok := k.values[key]; ok && reflect.DeepEqual(existing, value) {
+		return false, nil
+	}
+	k.values[key] = value
+	return true, k.write()
+}
+
+// Delete removes key and reports whether it existed.
+func (k *KVStore) Delete(key string) (bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	err := k.read()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := k.values[key]; !ok {
+		return false, nil
+	}
+	delete(k.values, key)
+	return true, k.write()
+}
+
+func (k *KVStore) Keys() ([]string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	err := k.read()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(k.values))
+	for key := range k.values {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (k *KVStore) read() error {
+	if k.loaded || !k.config.Persist {
+		return nil
+	}
+	b, err := os.ReadFile(k.config.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		k.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(b, &k.values)
+	if err != nil {
+		return err
+	}
+	k.loaded = true
+	return nil
+}
+
+func (k *KVStore) write() error {
+	if !k.config.Persist {
+		return nil
+	}
+	b, err := json.Marshal(k.values)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(k.config.Path, b)
+}
+
+func validKVKey(key string) error {
+	if key == "" {
+		return errors.New("kv key is required")
+	}
+	if strings.ContainsAny(key, "+#") {
+		return fmt.Errorf("kv key %q must not contain wildcards", key)
+	}
+	return nil
+}
+
+// KVChange is published on yokai/kv/changed/<key> whenever a value is set to
+// something new or deleted.
+type KVChange struct {
+	Key     string `json:"key"`
+	Value   any    `json:"value"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+func kvChanged(change KVChange) (map[Topic]Payload, error) {
+	message, err := json.Marshal(change)
+	if err != nil {
+		return nil, err
+	}
+	return map[Topic]Payload{
+		KVChangedTopic + "/" + change.Key: string(message),
+	}, nil
+}
+
+// KVCommand handles yokai/kv/set, yokai/kv/delete and yokai/kv/get through
+// one subscription and sequentially, so that a get sees every set and delete
+// published before it, in order.
+type KVCommand struct {
+	kv *KVStore
+}
+
+func (k KVCommand) Sequential() {}
+
+func (k KVCommand) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	switch topic {
+	case "yokai/kv/set":
+		return KVSetCommand(k).Command(ctx, topic, payload)
+	case "yokai/kv/delete":
+		return KVDeleteCommand(k).Command(ctx, topic, payload)
+	case "yokai/kv/get":
+		return KVGetCommand(k).Command(ctx, topic, payload)
+	default:
+		return nil, fmt.Errorf("unknown kv command %s", topic)
+	}
+}
+
+type KVSetCommand struct {
+	kv *KVStore
+}
+
+type KVSet struct {
+	Key   string
+	Value any
+}
+
+func (k KVSetCommand) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	var set KVSet
+	err := json.Unmarshal([]byte(payload), &set)
+	if err != nil {
+		return nil, err
+	}
+	err = validKVKey(set.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := k.kv.Set(set.Key, set.Value)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		return nil, nil
+	}
+	log.WithField("key", set.Key).
+		Info("set kv value")
+	return kvChanged(KVChange{Key: set.Key, Value: set.Value})
+}
+
+type KVDeleteCommand struct {
+	kv *KVStore
+}
+
+type KVDelete struct {
+	Key string
+}
+
+func (k KVDeleteCommand) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	var del KVDelete
+	err := json.Unmarshal([]byte(payload), &del)
+	if err != nil {
+		return nil, err
+	}
+	err = validKVKey(del.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := k.kv.Delete(del.Key)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, nil
+	}
+	log.WithField("key", del.Key).
+		Info("deleted kv value")
+	return kvChanged(KVChange{Key: del.Key, Deleted: true})
+}
+
+type KVGetCommand struct {
+	kv *KVStore
+}
+
+type KVGet struct {
+	Key   string
+	Topic Topic
+}
+
+// KVValue is the reply to a get. Missing keys have found set to false.
+type KVValue struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+	Found bool   `json:"found"`
+}
+
+func (k KVGetCommand) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	var get KVGet
+	err := json.Unmarshal([]byte(payload), &get)
+	if err != nil {
+		return nil, err
+	}
+	err = validKVKey(get.Key)
+	if err != nil {
+		return nil, err
+	}
+	if get.Topic == "" {
+		return nil, errors.New("kv get topic is required")
+	}
+
+	value, found, err := k.kv.Get(get.Key)
+	if err != nil {
+		return nil, err
+	}
+	message, err := json.Marshal(KVValue{Key: get.Key, Value: value, Found: found})
+	if err != nil {
+		return nil, err
+	}
+	return map[Topic]Payload{
+		get.Topic: string(message),
+	}, nil
+}
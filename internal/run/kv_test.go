@@ -0,0 +1,48 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestKVCommandsApplyInOrder(t *testing.T) {
+	kv := NewKVStore(KVConfig{})
+	registry, err := NewCommandRegistration(CommandsConfig{}, NewScheduler(time.Now), kv).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewBroker("source", BrokerConfig{})
+	sink := NewBroker("sink", BrokerConfig{})
+	replies, unsubscribe := source.Subscribe("reply", WithPolicy(PolicyUnbounded))
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g, gCtx := errgroup.WithContext(ctx)
+	NewCommanderPlugin(DefaultMaxHops, ErrorConfig{}).Start(gCtx, g, registry, source, nil, sink)
+
+	const count = 50
+	for i := range count {
+		sink.Publish("yokai/kv/set", fmt.Sprintf(`{"key":"counter","value":%d}`, i))
+		sink.Publish("yokai/kv/get", `{"key":"counter","topic":"reply"}`)
+	}
+
+	for i := range count {
+		select {
+		case tp := <-replies:
+			expected := fmt.Sprintf(`{"key":"counter","value":%d,"found":true}`, i)
+			if tp.Payload != expected {
+				t.Fatalf("expected %s, got %s", expected, tp.Payload)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for reply %d", i)
+		}
+	}
+
+	cancel()
+	_ = g.Wait()
+}
@@ -1,9 +1,48 @@
 local lib = import './lib.libsonnet';
 
+local resolveDependency(key, dependency) =
+  local parts = std.split(key, '/');
+  if std.startsWith(dependency, '/') then
+    dependency[1:]
+  else
+    std.join('/', parts[:std.length(parts) - 1] + [dependency]);
+
 local listApps(config) =
-  std.mapWithKey(function(key, app) {
-    init: std.get(app.app, 'init', null),
-    subscriptions: std.get(app.app, 'subscriptions', []),
-  }, lib.flattenObject(config));
+  local apps = lib.flattenObject(config);
+  std.mapWithKey(function(key, app)
+    local dependsOn = {
+      [dependency]:
+        local resolved = resolveDependency(key, dependency);
+        if resolved == key then
+          error 'app %s depends on itself' % key
+        else if !std.objectHas(apps, resolved) then
+          error 'app %s depends on unknown app %s' % [key, dependency]
+        else
+          resolved
+      for dependency in std.get(app.app, 'dependsOn', [])
+    };
+    local init = std.get(app.app, 'init', null);
+    local initResult = if std.isFunction(init) then init() else { model: init };
+    local subscriptions = std.get(app.app, 'subscriptions', []);
+    {
+      init: std.get(initResult, 'model', null),
+      initOutputs: {
+        [topic]: initResult[topic]
+        for topic in std.objectFields(initResult)
+        if topic != 'model'
+      },
+      subscriptions: [
+        if std.isString(subscription) then subscription else subscription.filter
+        for subscription in subscriptions
+      ],
+      codecs: {
+        [subscription.filter]: subscription.codec
+        for subscription in subscriptions
+        if std.isObject(subscription) && std.objectHas(subscription, 'codec')
+      },
+      outputs: std.get(app.app, 'outputs', []),
+      dependsOn: dependsOn,
+      migrate: std.objectHasAll(app.app, 'migrate'),
+    }, apps);
 
 listApps
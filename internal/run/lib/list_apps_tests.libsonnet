@@ -9,7 +9,12 @@ local exampleTests = {
       expected: {
         count: {
           init: { value: 0 },
+          initOutputs: {},
           subscriptions: ['yokai/test/input-a'],
+          codecs: {},
+          outputs: [],
+          dependsOn: {},
+          migrate: false,
         },
       },
     },
@@ -19,7 +24,162 @@ local exampleTests = {
       expected: {
         lighting: {
           init: null,
+          initOutputs: {},
           subscriptions: ['yokai/test/input-a'],
+          codecs: {},
+          outputs: [],
+          dependsOn: {},
+          migrate: false,
+        },
+      },
+    },
+  ],
+};
+
+local migrateTests = {
+  name: 'migrate',
+  tests: [
+    {
+      name: 'declared',
+      input:: {
+        counter: {
+          app: {
+            init: { value: 0 },
+            migrate(model): model,
+          },
+        },
+      },
+      expected: {
+        counter: {
+          init: { value: 0 },
+          initOutputs: {},
+          subscriptions: [],
+          codecs: {},
+          outputs: [],
+          dependsOn: {},
+          migrate: true,
+        },
+      },
+    },
+  ],
+};
+
+local initTests = {
+  name: 'init',
+  tests: [
+    {
+      name: 'function',
+      input:: import '../../../examples/startup/home.jsonnet',
+      expected: {
+        startup: {
+          init: { state: 'unknown' },
+          initOutputs: {
+            'zigbee2mqtt/lamp/get': { state: '' },
+          },
+          subscriptions: ['zigbee2mqtt/lamp'],
+          codecs: {},
+          outputs: [],
+          dependsOn: {},
+          migrate: false,
+        },
+      },
+    },
+  ],
+};
+
+local outputsTests = {
+  name: 'outputs',
+  tests: [
+    {
+      name: 'declared',
+      input:: {
+        ping: (import '../../../examples/pingpong/home.jsonnet').ping,
+      },
+      expected: {
+        ping: {
+          init: null,
+          initOutputs: {},
+          subscriptions: ['yokai/test/input-a', 'yokai/test/pong'],
+          codecs: {},
+          outputs: ['yokai/test/ping'],
+          dependsOn: {},
+          migrate: false,
+        },
+      },
+    },
+  ],
+};
+
+local codecsTests = {
+  name: 'codecs',
+  tests: [
+    {
+      name: 'declared',
+      input:: {
+        sensor: {
+          app: {
+            subscriptions: [
+              { filter: 'sensor/state', codec: 'text' },
+              { filter: 'sensor/frame' },
+              'sensor/json',
+            ],
+          },
+        },
+      },
+      expected: {
+        sensor: {
+          init: null,
+          initOutputs: {},
+          subscriptions: ['sensor/state', 'sensor/frame', 'sensor/json'],
+          codecs: { 'sensor/state': 'text' },
+          outputs: [],
+          dependsOn: {},
+          migrate: false,
+        },
+      },
+    },
+  ],
+};
+
+local dependsOnTests = {
+  name: 'dependsOn',
+  tests: [
+    {
+      name: 'sibling and absolute',
+      input:: {
+        away: { app: {} },
+        kitchen: {
+          window: { app: {} },
+          heater: { app: { dependsOn: ['window', '/away'] } },
+        },
+      },
+      expected: {
+        away: {
+          init: null,
+          initOutputs: {},
+          subscriptions: [],
+          codecs: {},
+          outputs: [],
+          dependsOn: {},
+          migrate: false,
+        },
+        'kitchen/window': {
+          init: null,
+          initOutputs: {},
+          subscriptions: [],
+          codecs: {},
+          outputs: [],
+          dependsOn: {},
+          migrate: false,
+        },
+        'kitchen/heater': {
+          init: null,
+          initOutputs: {},
+          subscriptions: [],
+          codecs: {},
+          outputs: [],
+          dependsOn: { window: 'kitchen/window', '/away': 'away' },
+          migrate: false,
         },
       },
     },
@@ -30,5 +190,10 @@ local exampleTests = {
   output(input): listApps(input),
   tests: [
     exampleTests,
+    migrateTests,
+    initTests,
+    outputsTests,
+    codecsTests,
+    dependsOnTests,
   ],
 }
@@ -0,0 +1,7 @@
+local lib = import './lib.libsonnet';
+
+local migrate(config, key, model) =
+  local app = lib.extractFromObject(config, key);
+  app.app.migrate(model);
+
+migrate
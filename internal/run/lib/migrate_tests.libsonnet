@@ -0,0 +1,35 @@
+local migrate = import './migrate.libsonnet';
+
+local config = {
+  counter: {
+    app: {
+      init: { value: 0, step: 1 },
+      migrate(model): {
+        value: model.value,
+        step: 1,
+      },
+    },
+  },
+};
+
+local migrateTests = {
+  name: 'migrate',
+  tests: [
+    {
+      name: 'add field',
+      input:: {
+        config: config,
+        key: 'counter',
+        model: { value: 4 },
+      },
+      expected: { value: 4, step: 1 },
+    },
+  ],
+};
+
+{
+  output(input): migrate(input.config, input.key, input.model),
+  tests: [
+    migrateTests,
+  ],
+}
@@ -1,7 +1,13 @@
 local lib = import './lib.libsonnet';
 
-local update(config, key, topic, payload, model) =
+local update(config, key, topic, payload, model, filter=topic, captures=[], deps={}) =
   local app = lib.extractFromObject(config, key);
-  app.app.update[topic](model, payload);
+  local handler = app.app.update[filter];
+  if std.length(handler) >= 4 then
+    handler(model, payload, { topic: topic, filter: filter, captures: captures }, deps)
+  else if std.length(handler) >= 3 then
+    handler(model, payload, { topic: topic, filter: filter, captures: captures })
+  else
+    handler(model, payload);
 
 update
@@ -20,9 +20,73 @@ local exampleTests = {
   ],
 };
 
+local wildcardTests = {
+  name: 'wildcard',
+  tests: [
+    {
+      name: 'captures',
+      input:: {
+        config: import '../../../examples/remotes/home.jsonnet',
+        key: 'remotes',
+        filter: 'zigbee2mqtt/+/action',
+        topic: 'zigbee2mqtt/kitchen/action',
+        captures: ['kitchen'],
+        payload: 'on',
+        model: { presses: {} },
+      },
+      expected: {
+        model: { presses: { kitchen: 1 } },
+        'yokai/test/output': { remote: 'kitchen', action: 'on', presses: 1 },
+      },
+    },
+  ],
+};
+
+local depsTests = {
+  name: 'deps',
+  tests: [
+    {
+      name: 'models',
+      input:: {
+        config: {
+          heater: {
+            app: {
+              dependsOn: ['window'],
+              update: {
+                'heater/set'(model, msg, meta, deps): {
+                  model: { on: msg.on && !deps.window.open },
+                },
+              },
+            },
+          },
+        },
+        key: 'heater',
+        topic: 'heater/set',
+        payload: { on: true },
+        model: { on: false },
+        deps: { window: { open: true } },
+      },
+      expected: {
+        model: { on: false },
+      },
+    },
+  ],
+};
+
 {
-  output(input): update(input.config, input.key, input.topic, input.payload, input.model),
+  output(input): update(
+    input.config,
+    input.key,
+    input.topic,
+    input.payload,
+    input.model,
+    std.get(input, 'filter', input.topic),
+    std.get(input, 'captures', []),
+    std.get(input, 'deps', {}),
+  ),
   tests: [
     exampleTests,
+    wildcardTests,
+    depsTests,
   ],
 }
@@ -25,7 +25,7 @@ local exampleTests = {
 };
 
 {
-  output(input): view(input.config, input.key, input.model),
+  output(input): view(input.config, input.key, input.model, false),
   tests: [
     exampleTests,
   ],
@@ -0,0 +1,56 @@
+package run
+
+import (
+	"context"
+	"sync"
+)
+
+type mailbox[T any] struct {
+	mu       sync.Mutex
+	messages []T
+	notify   chan struct{}
+}
+
+func newMailbox[T any]() *mailbox[T] {
+	return &mailbox[T]{
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (m *mailbox[T]) post(message T) {
+	m.mu.Lock()
+	m.messages = append(m.messages, message)
+	m.mu.Unlock()
+
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (m *mailbox[T]) receive(ctx context.Context) (T, error) {
+	var zero T
+	for {
+		m.mu.Lock()
+		if len(m.messages) > 0 {
+			message := m.messages[0]
+			m.messages[0] = zero
+			m.messages = m.messages[1:]
+			m.mu.Unlock()
+			return message, nil
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case <-m.notify:
+		}
+	}
+}
+
+func (m *mailbox[T]) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.messages)
+}
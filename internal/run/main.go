@@ -2,23 +2,76 @@ package run
 
 import (
 	"context"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 )
 
-func Run(ctx context.Context, registration Registration, plugins []Plugin) error {
+func Run(ctx context.Context, config BrokerConfig, registration Registration, plugins []Plugin) error {
 	registry, err := registration.Register()
 	if err != nil {
 		return err
 	}
-	source := NewBroker()
-	view := NewBroker()
-	sink := NewBroker()
+	sourceBroker := NewBroker("source", config)
+	viewBroker := NewBroker("view", config)
+	sinkBroker := NewBroker("sink", config)
+
+	source, err := intercept("source", sourceBroker, config.Interceptors.Source)
+	if err != nil {
+		return err
+	}
+	view, err := intercept("view", viewBroker, config.Interceptors.View)
+	if err != nil {
+		return err
+	}
+	sink, err := intercept("sink", sinkBroker, config.Interceptors.Sink)
+	if err != nil {
+		return err
+	}
 
 	g, gCtx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		reportDropped(gCtx, config.StatsInterval, sourceBroker, viewBroker, sinkBroker)
+		return nil
+	})
 	for _, plugin := range plugins {
 		plugin.Start(gCtx, g, registry, source, view, sink)
 	}
 
+	for _, tp := range registry.InitOutputs {
+		log.WithField("topic", tp.Topic).
+			WithField("payload", tp.Payload).
+			Info("publishing init output to topic")
+		sink.PublishMessage(tp)
+	}
+
 	return g.Wait()
 }
+
+// reportDropped logs the subscriptions that dropped messages once every
+// interval and a last time when ctx is done, so that a slow subscriber shows
+// up while yokai runs and not only when it stops.
+func reportDropped(ctx context.Context, interval time.Duration, brokers ...*MutexBroker) {
+	if interval <= 0 {
+		interval = DefaultStatsInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reported := make([]map[*subscription]uint64, len(brokers))
+	report := func() {
+		for i, broker := range brokers {
+			reported[i] = broker.logDropped(reported[i])
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			report()
+			return
+		case <-ticker.C:
+			report()
+		}
+	}
+}
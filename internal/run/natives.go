@@ -0,0 +1,263 @@
+package run
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+)
+
+// Clock returns the current time. Tests replace it to freeze time.
+type Clock func() time.Time
+
+func (c Clock) now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c()
+}
+
+func FixedClock(t time.Time) Clock {
+	return func() time.Time {
+		return t
+	}
+}
+
+// NativeFunctions are available to app code as std.native('yokai.<name>').
+// Every VM that evaluates app code, including the ones of the test runners,
+// registers them.
+func NativeFunctions(clock Clock) []*jsonnet.NativeFunction {
+	return []*jsonnet.NativeFunction{
+		{
+			Name:   "yokai.now",
+			Params: ast.Identifiers{},
+			Func: func(args []any) (any, error) {
+				return clock.now().UTC().Format(time.RFC3339Nano), nil
+			},
+		},
+		{
+			Name:   "yokai.unixMillis",
+			Params: ast.Identifiers{"time"},
+			Func: func(args []any) (any, error) {
+				t, err := timeArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				return float64(t.UnixMilli()), nil
+			},
+		},
+		{
+			Name:   "yokai.parseTime",
+			Params: ast.Identifiers{"value", "layout", "timezone"},
+			Func: func(args []any) (any, error) {
+				value, layout, timezone, err := stringArgs3(args)
+				if err != nil {
+					return nil, err
+				}
+				location, err := loadLocation(timezone)
+				if err != nil {
+					return nil, err
+				}
+				t, err := time.ParseInLocation(timeLayout(layout), value, location)
+				if err != nil {
+					return nil, err
+				}
+				return t.UTC().Format(time.RFC3339Nano), nil
+			},
+		},
+		{
+			Name:   "yokai.formatTime",
+			Params: ast.Identifiers{"time", "layout", "timezone"},
+			Func: func(args []any) (any, error) {
+				t, err := timeArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				_, layout, timezone, err := stringArgs3([]any{"", args[1], args[2]})
+				if err != nil {
+					return nil, err
+				}
+				location, err := loadLocation(timezone)
+				if err != nil {
+					return nil, err
+				}
+				return t.In(location).Format(timeLayout(layout)), nil
+			},
+		},
+		{
+			Name:   "yokai.timeParts",
+			Params: ast.Identifiers{"time", "timezone"},
+			Func: func(args []any) (any, error) {
+				t, err := timeArg(args[0])
+				if err != nil {
+					return nil, err
+				}
+				timezone, ok := args[1].(string)
+				if !ok {
+					return nil, fmt.Errorf("timezone must be a string")
+				}
+				location, err := loadLocation(timezone)
+				if err != nil {
+					return nil, err
+				}
+				t = t.In(location)
+				return map[string]any{
+					"year":    float64(t.Year()),
+					"month":   float64(t.Month()),
+					"day":     float64(t.Day()),
+					"hour":    float64(t.Hour()),
+					"minute":  float64(t.Minute()),
+					"second":  float64(t.Second()),
+					"weekday": float64(t.Weekday()),
+				}, nil
+			},
+		},
+		{
+			Name:   "yokai.regexMatch",
+			Params: ast.Identifiers{"pattern", "value"},
+			Func: func(args []any) (any, error) {
+				re, value, err := regexArgs(args)
+				if err != nil {
+					return nil, err
+				}
+				return re.MatchString(value), nil
+			},
+		},
+		{
+			Name:   "yokai.regexFind",
+			Params: ast.Identifiers{"pattern", "value"},
+			Func: func(args []any) (any, error) {
+				re, value, err := regexArgs(args)
+				if err != nil {
+					return nil, err
+				}
+				matches := re.FindStringSubmatch(value)
+				if matches == nil {
+					return nil, nil
+				}
+				res := make([]any, 0, len(matches))
+				for _, match := range matches {
+					res = append(res, match)
+				}
+				return res, nil
+			},
+		},
+		{
+			Name:   "yokai.regexReplace",
+			Params: ast.Identifiers{"pattern", "value", "replacement"},
+			Func: func(args []any) (any, error) {
+				re, value, err := regexArgs(args[:2])
+				if err != nil {
+					return nil, err
+				}
+				replacement, ok := args[2].(string)
+				if !ok {
+					return nil, fmt.Errorf("replacement must be a string")
+				}
+				return re.ReplaceAllString(value, replacement), nil
+			},
+		},
+		{
+			Name:   "yokai.sha256",
+			Params: ast.Identifiers{"value"},
+			Func: func(args []any) (any, error) {
+				value, ok := args[0].(string)
+				if !ok {
+					return nil, fmt.Errorf("value must be a string")
+				}
+				sum := sha256.Sum256([]byte(value))
+				return hex.EncodeToString(sum[:]), nil
+			},
+		},
+		{
+			Name:   "yokai.md5",
+			Params: ast.Identifiers{"value"},
+			Func: func(args []any) (any, error) {
+				value, ok := args[0].(string)
+				if !ok {
+					return nil, fmt.Errorf("value must be a string")
+				}
+				sum := md5.Sum([]byte(value))
+				return hex.EncodeToString(sum[:]), nil
+			},
+		},
+		{
+			Name:   "yokai.uuid",
+			Params: ast.Identifiers{},
+			Func: func(args []any) (any, error) {
+				return newUUID()
+			},
+		},
+	}
+}
+
+// timeArg accepts either an RFC 3339 string or unix milliseconds.
+func timeArg(arg any) (time.Time, error) {
+	switch arg := arg.(type) {
+	case string:
+		return time.Parse(time.RFC3339Nano, arg)
+	case float64:
+		return time.UnixMilli(int64(arg)).UTC(), nil
+	default:
+		return time.Time{}, fmt.Errorf("time must be an RFC 3339 string or unix milliseconds")
+	}
+}
+
+func timeLayout(layout string) string {
+	if layout == "" {
+		return time.RFC3339Nano
+	}
+	return layout
+}
+
+func loadLocation(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(timezone)
+}
+
+func stringArgs3(args []any) (string, string, string, error) {
+	var res [3]string
+	for i, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			return "", "", "", fmt.Errorf("argument %d must be a string", i+1)
+		}
+		res[i] = s
+	}
+	return res[0], res[1], res[2], nil
+}
+
+func regexArgs(args []any) (*regexp.Regexp, string, error) {
+	pattern, ok := args[0].(string)
+	if !ok {
+		return nil, "", fmt.Errorf("pattern must be a string")
+	}
+	value, ok := args[1].(string)
+	if !ok {
+		return nil, "", fmt.Errorf("value must be a string")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	return re, value, nil
+}
+
+func newUUID() (string, error) {
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,58 @@
+package run
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestUpdateCallsNatives runs an app that calls the native functions, which
+// the jsonnet lib tests cannot register.
+func TestUpdateCallsNatives(t *testing.T) {
+	config, err := filepath.Abs("../../examples/nightlight/home.jsonnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		now      time.Time
+		expected map[string]any
+	}{
+		{
+			name: "night",
+			now:  time.Date(2024, 1, 1, 22, 30, 0, 0, time.UTC),
+			expected: map[string]any{
+				"yokai/test/output": map[string]any{"at": "2024-01-01T22:30:00Z", "brightness": 30.0, "room": "hallway"},
+			},
+		},
+		{
+			name: "day",
+			now:  time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+			expected: map[string]any{
+				"yokai/test/output": map[string]any{"at": "2024-01-01T12:00:00Z", "brightness": 255.0, "room": "hallway"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			appLib := AppLib{config: config, clock: FixedClock(test.now)}.withVMPool()
+			actual, err := appLib.update(
+				"nightlight",
+				"yokai/test/input-a",
+				"yokai/test/input-a",
+				[]string{},
+				`{"device":"hallway-sensor"}`,
+				map[string]any{},
+				map[string]any{},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
@@ -13,6 +13,16 @@ type Key = string
 type TopicPayload struct {
 	Topic   Topic
 	Payload Payload
+
+	// Origin identifies the message that started a chain of app outputs,
+	// Hops counts how often the chain was routed from the sink back into
+	// the source.
+	Origin string
+	Hops   int
+
+	// Qos and Retain are passed on to MQTT when the message is published.
+	Qos    byte
+	Retain bool
 }
 
 type Registration interface {
@@ -24,6 +34,8 @@ type Registry struct {
 	KeyToModel    map[Key]Model
 
 	TopicToCommands map[Topic][]Command
+
+	InitOutputs []TopicPayload
 }
 
 func NewRegistry() Registry {
@@ -41,14 +53,43 @@ type Plugin interface {
 
 type Model interface {
 	Key() string
-	Update(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error)
+	// Update handles a message on topic, which matched the model's
+	// subscription filter.
+	Update(ctx context.Context, filter Topic, topic Topic, payload Payload) ([]TopicPayload, error)
 	View(ctx context.Context) (string, error)
 }
 
+// DescribedModel is implemented by models that can list their declared
+// subscriptions and outputs and expose their current state, such as apps.
+type DescribedModel interface {
+	Model
+	Subscriptions() []Topic
+	Outputs() []Topic
+	State(ctx context.Context) (any, error)
+}
+
 type Command interface {
 	Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error)
 }
 
+// SequentialCommand is implemented by commands that must handle the messages
+// on their topic one at a time, in the order they were published. The
+// commander runs other commands concurrently.
+type SequentialCommand interface {
+	Command
+	Sequential()
+}
+
+// DeferredCommand is implemented by commands that accept their messages one
+// at a time, in the order they were published, but produce their outputs
+// only after a wait. The commander calls Defer inline and runs the returned
+// wait concurrently, publishing its outputs once it returns, as the start of
+// a new message chain. A nil wait has no outputs.
+type DeferredCommand interface {
+	Command
+	Defer(ctx context.Context, topic Topic, payload Payload) (func() (map[Topic]Payload, error), error)
+}
+
 type CompoundRegistration struct {
 	registrations []Registration
 }
@@ -75,6 +116,7 @@ func (c CompoundRegistration) Register() (Registry, error) {
 		for topic, commands := range registry.TopicToCommands {
 			res.TopicToCommands[topic] = append(res.TopicToCommands[topic], commands...)
 		}
+		res.InitOutputs = append(res.InitOutputs, registry.InitOutputs...)
 	}
 	return res, nil
 }
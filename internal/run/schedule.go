@@ -0,0 +1,275 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+type Schedule struct {
+	Id       string
+	Cron     string
+	At       string
+	Timezone string
+	Topic    Topic
+	Message  any
+}
+
+type CancelSchedule struct {
+	Id string
+}
+
+type scheduleEntry struct {
+	schedule Schedule
+	cron     *cronSchedule
+	location *time.Location
+	next     time.Time
+}
+
+// ScheduleCommandFilter subscribes ScheduleCommand to yokai/schedule and
+// yokai/schedule/cancel at once.
+const ScheduleCommandFilter = "yokai/schedule/#"
+
+// Scheduler keeps scheduled entries outside a single run, so that they
+// survive hot reloads and keep firing once the next run starts. It reads the
+// time from clock, so that schedules agree with the time apps see.
+type Scheduler struct {
+	clock   Clock
+	mu      sync.Mutex
+	entries map[string]*scheduleEntry
+	changed chan struct{}
+}
+
+func NewScheduler(clock Clock) *Scheduler {
+	return &Scheduler{
+		clock:   clock,
+		entries: make(map[string]*scheduleEntry),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+func (s *Scheduler) Schedule(schedule Schedule) error {
+	if schedule.Id == "" {
+		return errors.New("schedule id is required")
+	}
+	if schedule.Topic == "" {
+		return errors.New("schedule topic is required")
+	}
+
+	location := time.Local
+	if schedule.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(schedule.Timezone)
+		if err != nil {
+			return err
+		}
+	}
+
+	entry := &scheduleEntry{
+		schedule: schedule,
+		location: location,
+	}
+	switch {
+	case schedule.Cron != "" && schedule.At != "":
+		return errors.New("schedule must have either cron or at, not both")
+	case schedule.Cron != "":
+		cron, err := parseCron(schedule.Cron)
+		if err != nil {
+			return err
+		}
+		entry.cron = cron
+		entry.next = cron.next(s.clock.now().In(location))
+		if entry.next.IsZero() {
+			return fmt.Errorf("cron expression %q never fires", schedule.Cron)
+		}
+	case schedule.At != "":
+		at, err := time.Parse(time.RFC3339, schedule.At)
+		if err != nil {
+			return err
+		}
+		entry.next = at
+	default:
+		return errors.New("schedule must have either cron or at")
+	}
+
+	s.mu.Lock()
+	s.entries[schedule.Id] = entry
+	s.mu.Unlock()
+	s.notify()
+
+	log.WithField("id", schedule.Id).
+		WithField("next", entry.next).
+		Info("scheduled message")
+	return nil
+}
+
+func (s *Scheduler) Cancel(id string) {
+	s.mu.Lock()
+	_, ok := s.entries[id]
+	delete(s.entries, id)
+	s.mu.Unlock()
+
+	if ok {
+		log.WithField("id", id).
+			Info("cancelled schedule")
+		s.notify()
+	}
+}
+
+func (s *Scheduler) Entries() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]Schedule, 0, len(s.entries))
+	for _, entry := range s.entries {
+		res = append(res, entry.schedule)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Id < res[j].Id
+	})
+	return res
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) nextFire() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, entry := range s.entries {
+		if next.IsZero() || entry.next.Before(next) {
+			next = entry.next
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func (s *Scheduler) due(now time.Time) []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []Schedule
+	for id, entry := range s.entries {
+		if entry.next.After(now) {
+			continue
+		}
+		res = append(res, entry.schedule)
+		if entry.cron == nil {
+			delete(s.entries, id)
+			continue
+		}
+		entry.next = entry.cron.next(now.In(entry.location))
+		if entry.next.IsZero() {
+			delete(s.entries, id)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Id < res[j].Id
+	})
+	return res
+}
+
+func (s *Scheduler) Start(ctx context.Context, g *errgroup.Group, registry Registry, source Broker, view Broker, sink Broker) {
+	g.Go(func() error {
+		schedulerCtx, schedulerCancel := context.WithCancel(ctx)
+		defer schedulerCancel()
+
+		err := runScheduler(schedulerCtx, s, source)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return nil
+	})
+}
+
+func runScheduler(ctx context.Context, scheduler *Scheduler, source Broker) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		var fire <-chan time.Time
+		if next, ok := scheduler.nextFire(); ok {
+			timer.Reset(next.Sub(scheduler.clock.now()))
+			fire = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-scheduler.changed:
+		case <-fire:
+			for _, schedule := range scheduler.due(scheduler.clock.now()) {
+				message, err := json.Marshal(schedule.Message)
+				if err != nil {
+					log.WithError(err).
+						WithField("id", schedule.Id).
+						Error("failed to marshal scheduled message")
+					continue
+				}
+				log.WithField("id", schedule.Id).
+					WithField("topic", schedule.Topic).
+					Info("firing scheduled message")
+				source.Publish(schedule.Topic, string(message))
+			}
+		}
+	}
+}
+
+// ScheduleCommand handles yokai/schedule and yokai/schedule/cancel through
+// one subscription and sequentially, so that a cancel right after a schedule
+// always cancels it.
+type ScheduleCommand struct {
+	scheduler *Scheduler
+}
+
+func (s ScheduleCommand) Sequential() {}
+
+func (s ScheduleCommand) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	switch topic {
+	case "yokai/schedule":
+	case "yokai/schedule/cancel":
+		return CancelScheduleCommand(s).Command(ctx, topic, payload)
+	default:
+		return nil, fmt.Errorf("unknown schedule command %s", topic)
+	}
+
+	var schedule Schedule
+	err := json.Unmarshal([]byte(payload), &schedule)
+	if err != nil {
+		return nil, err
+	}
+	return nil, s.scheduler.Schedule(schedule)
+}
+
+type CancelScheduleCommand struct {
+	scheduler *Scheduler
+}
+
+func (c CancelScheduleCommand) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	var cancel CancelSchedule
+	err := json.Unmarshal([]byte(payload), &cancel)
+	if err != nil {
+		return nil, err
+	}
+	c.scheduler.Cancel(cancel.Id)
+	return nil, nil
+}
@@ -0,0 +1,112 @@
+package run
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestSchedulerUsesClock(t *testing.T) {
+	now := time.Date(2024, 6, 3, 5, 0, 0, 0, time.UTC)
+	scheduler := NewScheduler(FixedClock(now))
+
+	err := scheduler.Schedule(Schedule{Id: "alarm", Cron: "30 6 * * *", Timezone: "UTC", Topic: "wake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, ok := scheduler.nextFire()
+	if !ok || !next.Equal(time.Date(2024, 6, 3, 6, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected the alarm at 06:30 of the clock's day, got %v", next)
+	}
+
+	if due := scheduler.due(now); len(due) != 0 {
+		t.Errorf("expected nothing due yet, got %v", due)
+	}
+	due := scheduler.due(now.Add(2 * time.Hour))
+	if len(due) != 1 || due[0].Id != "alarm" {
+		t.Fatalf("expected the alarm to be due, got %v", due)
+	}
+	next, _ = scheduler.nextFire()
+	if !next.Equal(time.Date(2024, 6, 4, 6, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected the alarm to be re-armed for the next day, got %v", next)
+	}
+}
+
+func TestSchedulerFiresAtOnlyOnce(t *testing.T) {
+	now := time.Date(2024, 6, 3, 5, 0, 0, 0, time.UTC)
+	scheduler := NewScheduler(FixedClock(now))
+
+	err := scheduler.Schedule(Schedule{Id: "alarm", At: "2024-06-03T04:00:00Z", Topic: "wake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due := scheduler.due(now); len(due) != 1 {
+		t.Fatalf("expected the alarm to be due, got %v", due)
+	}
+	if _, ok := scheduler.nextFire(); ok {
+		t.Error("expected no entries left")
+	}
+}
+
+func TestSchedulerRejectsInvalid(t *testing.T) {
+	tests := map[string]Schedule{
+		"no id":       {Cron: "* * * * *", Topic: "wake"},
+		"no topic":    {Id: "alarm", Cron: "* * * * *"},
+		"neither":     {Id: "alarm", Topic: "wake"},
+		"both":        {Id: "alarm", Cron: "* * * * *", At: "2024-06-03T04:00:00Z", Topic: "wake"},
+		"bad cron":    {Id: "alarm", Cron: "* * *", Topic: "wake"},
+		"bad at":      {Id: "alarm", At: "tomorrow", Topic: "wake"},
+		"bad zone":    {Id: "alarm", Cron: "* * * * *", Timezone: "Nowhere/Else", Topic: "wake"},
+		"never fires": {Id: "alarm", Cron: "0 0 30 2 *", Topic: "wake"},
+	}
+	for name, schedule := range tests {
+		t.Run(name, func(t *testing.T) {
+			scheduler := NewScheduler(time.Now)
+			if err := scheduler.Schedule(schedule); err == nil {
+				t.Error("expected the schedule to be rejected")
+			}
+		})
+	}
+}
+
+func TestScheduleCancelledRightAfterPublish(t *testing.T) {
+	scheduler := NewScheduler(time.Now)
+	registry, err := NewCommandRegistration(CommandsConfig{}, scheduler, NewKVStore(KVConfig{})).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewBroker("source", BrokerConfig{})
+	sink := NewBroker("sink", BrokerConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g, gCtx := errgroup.WithContext(ctx)
+	NewCommanderPlugin(DefaultMaxHops, ErrorConfig{}).Start(gCtx, g, registry, source, nil, sink)
+
+	for range 200 {
+		sink.Publish("yokai/schedule", `{"id":"alarm","cron":"30 6 * * *","topic":"wake"}`)
+		sink.Publish("yokai/schedule/cancel", `{"id":"alarm"}`)
+	}
+	sink.Publish("yokai/schedule", `{"id":"last","cron":"30 6 * * *","topic":"wake"}`)
+
+	var ids []string
+	for range 100 {
+		ids = ids[:0]
+		for _, schedule := range scheduler.Entries() {
+			ids = append(ids, schedule.Id)
+		}
+		if slices.Contains(ids, "last") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !slices.Equal(ids, []string{"last"}) {
+		t.Errorf("expected only the last schedule to remain, got %v", ids)
+	}
+
+	cancel()
+	_ = g.Wait()
+}
@@ -0,0 +1,229 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+type StateConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	Path          string        `mapstructure:"path"`
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+}
+
+// Snapshot is the persisted state of an app. Init is the app's init at the
+// time the model was saved, so that a changed model shape can be detected
+// and migrated after a restart.
+type Snapshot struct {
+	Model any `json:"model"`
+	Init  any `json:"init"`
+}
+
+type StateStore interface {
+	Load(key Key) (Snapshot, bool, error)
+	Save(key Key, snapshot Snapshot)
+	Delete(key Key)
+}
+
+type NoopStateStore struct{}
+
+func (n NoopStateStore) Load(key Key) (Snapshot, bool, error) {
+	return Snapshot{}, false, nil
+}
+
+func (n NoopStateStore) Save(key Key, snapshot Snapshot) {}
+
+func (n NoopStateStore) Delete(key Key) {}
+
+type FileStateStore struct {
+	config StateConfig
+
+	mu        sync.Mutex
+	loaded    bool
+	snapshots map[Key]Snapshot
+	dirty     bool
+}
+
+type stateFile struct {
+	Version int              `json:"version"`
+	Apps    map[Key]Snapshot `json:"apps"`
+}
+
+const stateFileVersion = 1
+
+func NewFileStateStore(config StateConfig) *FileStateStore {
+	return &FileStateStore{
+		config:    config,
+		snapshots: make(map[Key]Snapshot),
+	}
+}
+
+func (f *FileStateStore) Load(key Key) (Snapshot, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.read()
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+	snapshot, ok := f.snapshots[key]
+	return snapshot, ok, nil
+}
+
+func (f *FileStateStore) Save(key Key, snapshot Snapshot) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.snapshots[key] = snapshot
+	f.dirty = true
+}
+
+func (f *FileStateStore) Delete(key Key) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := f.read()
+	if err != nil {
+		log.WithError(err).
+			WithField("path", f.config.Path).
+			Error("failed to read state")
+	}
+	if _, ok := f.snapshots[key]; !ok {
+		return
+	}
+	delete(f.snapshots, key)
+	f.dirty = true
+}
+
+func (f *FileStateStore) read() error {
+	if f.loaded {
+		return nil
+	}
+	b, err := os.ReadFile(f.config.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		f.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	snapshots, err := decodeStateFile(b)
+	if err != nil {
+		return err
+	}
+	for key, snapshot := range snapshots {
+		if _, ok := f.snapshots[key]; !ok {
+			f.snapshots[key] = snapshot
+		}
+	}
+	f.loaded = true
+	return nil
+}
+
+func decodeStateFile(b []byte) (map[Key]Snapshot, error) {
+	var file stateFile
+	err := json.Unmarshal(b, &file)
+	if err != nil {
+		return nil, err
+	}
+	if file.Version != stateFileVersion {
+		return nil, fmt.Errorf("unsupported state file version %d", file.Version)
+	}
+	return file.Apps, nil
+}
+
+func (f *FileStateStore) Flush() error {
+	f.mu.Lock()
+	if !f.dirty {
+		f.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(stateFile{Version: stateFileVersion, Apps: f.snapshots})
+	f.dirty = false
+	f.mu.Unlock()
+	if err == nil {
+		err = writeFileAtomic(f.config.Path, b)
+	}
+	if err != nil {
+		f.mu.Lock()
+		f.dirty = true
+		f.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FileStateStore) Start(ctx context.Context, g *errgroup.Group, registry Registry, source Broker, view Broker, sink Broker) {
+	g.Go(func() error {
+		stateCtx, stateCancel := context.WithCancel(ctx)
+		defer stateCancel()
+
+		err := runStateFlush(stateCtx, f)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
+		}
+		return nil
+	})
+}
+
+func runStateFlush(ctx context.Context, store *FileStateStore) error {
+	interval := store.config.FlushInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			err := store.Flush()
+			if err != nil {
+				log.WithError(err).
+					WithField("path", store.config.Path).
+					Error("failed to flush state")
+			}
+			return ctx.Err()
+		case <-ticker.C:
+			err := store.Flush()
+			if err != nil {
+				log.WithError(err).
+					WithField("path", store.config.Path).
+					Error("failed to flush state")
+			}
+		}
+	}
+}
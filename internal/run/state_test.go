@@ -0,0 +1,212 @@
+package run
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestFileStateStoreRestores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "state.json")
+	store := NewFileStateStore(StateConfig{Path: path})
+
+	_, ok, err := store.Load("counter")
+	if err != nil || ok {
+		t.Fatalf("expected no snapshot before the first flush, got %v, %v", ok, err)
+	}
+	store.Save("counter", Snapshot{Model: map[string]any{"count": 3.0}, Init: map[string]any{"count": 0.0}})
+	err = store.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewFileStateStore(StateConfig{Path: path})
+	snapshot, ok, err := restored.Load("counter")
+	if err != nil || !ok {
+		t.Fatalf("expected the snapshot to be restored, got %v, %v", ok, err)
+	}
+	expected := Snapshot{Model: map[string]any{"count": 3.0}, Init: map[string]any{"count": 0.0}}
+	if !reflect.DeepEqual(snapshot, expected) {
+		t.Errorf("expected %v, got %v", expected, snapshot)
+	}
+}
+
+func TestFileStateStoreKeepsNewerSaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	writeState(t, path, `{"version":1,"apps":{"counter":{"model":1,"init":0},"other":{"model":2,"init":0}}}`)
+
+	store := NewFileStateStore(StateConfig{Path: path})
+	store.Save("counter", Snapshot{Model: 5.0, Init: 0.0})
+
+	snapshot, _, err := store.Load("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Model != 5.0 {
+		t.Errorf("expected the saved model to win over the file, got %v", snapshot.Model)
+	}
+	snapshot, ok, err := store.Load("other")
+	if err != nil || !ok || snapshot.Model != 2.0 {
+		t.Errorf("expected the other model to be read from the file, got %v, %v, %v", snapshot.Model, ok, err)
+	}
+}
+
+func TestFileStateStoreFlushesOnlyWhenDirty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store := NewFileStateStore(StateConfig{Path: path})
+
+	err := store.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected no state file without changes")
+	}
+
+	store.Save("counter", Snapshot{Model: 1.0, Init: 0.0})
+	err = store.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected a clean store not to write again")
+	}
+}
+
+func TestFileStateStoreRetriesFailedFlush(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "state")
+	writeState(t, blocker, "")
+	path := filepath.Join(blocker, "state.json")
+	store := NewFileStateStore(StateConfig{Path: path})
+
+	store.Save("counter", Snapshot{Model: 1.0, Init: 0.0})
+	err := store.Flush()
+	if err == nil {
+		t.Fatal("expected the flush to fail while the directory is blocked")
+	}
+
+	err = os.Remove(blocker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok, err := NewFileStateStore(StateConfig{Path: path}).Load("counter")
+	if err != nil || !ok {
+		t.Errorf("expected the failed flush to be retried, got %v, %v", ok, err)
+	}
+}
+
+func TestFileStateStoreWritesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	writeState(t, path, `{"version":1,"apps":{}}`)
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewFileStateStore(StateConfig{Path: path})
+	store.Save("counter", Snapshot{Model: 1.0, Init: 0.0})
+	err = store.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Error("expected the state file to be replaced rather than written in place")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left behind, got %v", entries)
+	}
+}
+
+func TestFileStateStoreDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	writeState(t, path, `{"version":1,"apps":{"counter":{"model":1,"init":0},"other":{"model":2,"init":0}}}`)
+
+	store := NewFileStateStore(StateConfig{Path: path})
+	store.Delete("counter")
+	store.Delete("missing")
+	err := store.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewFileStateStore(StateConfig{Path: path})
+	if _, ok, _ := restored.Load("counter"); ok {
+		t.Error("expected the deleted snapshot to be gone")
+	}
+	if _, ok, _ := restored.Load("other"); !ok {
+		t.Error("expected the other snapshot to be kept")
+	}
+}
+
+func TestFileStateStoreRejectsUnknownVersion(t *testing.T) {
+	tests := map[string]string{
+		"bare models":   `{"counter":1}`,
+		"newer version": `{"version":2,"apps":{}}`,
+		"invalid json":  `{`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			writeState(t, path, content)
+			_, _, err := NewFileStateStore(StateConfig{Path: path}).Load("counter")
+			if err == nil {
+				t.Error("expected the state file to be rejected")
+			}
+		})
+	}
+}
+
+func TestFileStateStoreFlushesOnStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store := NewFileStateStore(StateConfig{Path: path})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, gCtx := errgroup.WithContext(ctx)
+	store.Start(gCtx, g, Registry{}, nil, nil, nil)
+	store.Save("counter", Snapshot{Model: 1.0, Init: 0.0})
+	cancel()
+	err := g.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok, err := NewFileStateStore(StateConfig{Path: path}).Load("counter")
+	if err != nil || !ok {
+		t.Errorf("expected the state to be flushed on stop, got %v, %v", ok, err)
+	}
+}
+
+func writeState(t *testing.T, path string, content string) {
+	t.Helper()
+	err := os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
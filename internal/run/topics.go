@@ -0,0 +1,96 @@
+package run
+
+import (
+	"strings"
+)
+
+// MatchTopic reports whether topic matches the MQTT-style filter. Filters may
+// contain the single-level wildcard "+" and a trailing multi-level wildcard
+// "#". The returned captures hold the topic levels matched by each wildcard,
+// in order, with "#" capturing the remaining levels joined by "/".
+func MatchTopic(filter Topic, topic Topic) ([]string, bool) {
+	if !IsWildcard(filter) {
+		return nil, filter == topic
+	}
+	if strings.HasPrefix(topic, "$") {
+		return nil, false
+	}
+
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	captures := []string{}
+	for i, level := range filterLevels {
+		switch {
+		case level == "#":
+			return append(captures, strings.Join(topicLevels[min(i, len(topicLevels)):], "/")), true
+		case i >= len(topicLevels):
+			return nil, false
+		case level == "+":
+			captures = append(captures, topicLevels[i])
+		case level != topicLevels[i]:
+			return nil, false
+		}
+	}
+	if len(filterLevels) != len(topicLevels) {
+		return nil, false
+	}
+	return captures, true
+}
+
+// CutTopicPrefix reports whether topic lies under prefix, matching whole
+// levels, so that "lights" covers "lights" and "lights/kitchen" but not
+// "lightsout". It returns the rest of topic after prefix, which is empty or
+// starts with "/". The empty prefix covers every topic and leaves it whole.
+func CutTopicPrefix(topic Topic, prefix Topic) (Topic, bool) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return topic, true
+	}
+	rest, ok := strings.CutPrefix(topic, prefix)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return "", false
+	}
+	return rest, true
+}
+
+// MatchFirst returns the first of filters, in their given order, that topic
+// matches, together with its captures.
+func MatchFirst(filters []Topic, topic Topic) (Topic, []string, bool) {
+	for _, filter := range filters {
+		if captures, ok := MatchTopic(filter, topic); ok {
+			return filter, captures, true
+		}
+	}
+	return "", nil, false
+}
+
+// MatchesAny reports whether topic matches at least one of filters.
+func MatchesAny(filters []Topic, topic Topic) bool {
+	_, _, ok := MatchFirst(filters, topic)
+	return ok
+}
+
+func IsWildcard(filter Topic) bool {
+	return strings.ContainsAny(filter, "+#")
+}
+
+// FiltersOverlap reports whether some topic matches both filters.
+func FiltersOverlap(a Topic, b Topic) bool {
+	aLevels := strings.Split(a, "/")
+	bLevels := strings.Split(b, "/")
+	for i := 0; i < len(aLevels) && i < len(bLevels); i++ {
+		if aLevels[i] == "#" || bLevels[i] == "#" {
+			return true
+		}
+		if aLevels[i] != "+" && bLevels[i] != "+" && aLevels[i] != bLevels[i] {
+			return false
+		}
+	}
+	if len(aLevels) == len(bLevels) {
+		return true
+	}
+	if len(aLevels) == len(bLevels)+1 && aLevels[len(aLevels)-1] == "#" {
+		return true
+	}
+	return len(bLevels) == len(aLevels)+1 && bLevels[len(bLevels)-1] == "#"
+}
@@ -0,0 +1,56 @@
+package run
+
+import "testing"
+
+func TestCutTopicPrefix(t *testing.T) {
+	tests := []struct {
+		topic  Topic
+		prefix Topic
+		rest   Topic
+		ok     bool
+	}{
+		{topic: "lights", prefix: "lights", rest: "", ok: true},
+		{topic: "lights/kitchen", prefix: "lights", rest: "/kitchen", ok: true},
+		{topic: "lights/kitchen", prefix: "lights/", rest: "/kitchen", ok: true},
+		{topic: "lightsout", prefix: "lights", ok: false},
+		{topic: "lights", prefix: "lights/kitchen", ok: false},
+		{topic: "lights/kitchen", prefix: "", rest: "lights/kitchen", ok: true},
+	}
+	for _, test := range tests {
+		rest, ok := CutTopicPrefix(test.topic, test.prefix)
+		if rest != test.rest || ok != test.ok {
+			t.Errorf("CutTopicPrefix(%q, %q) = %q, %v, expected %q, %v", test.topic, test.prefix, rest, ok, test.rest, test.ok)
+		}
+	}
+}
+
+func TestRenameInterceptor(t *testing.T) {
+	tests := []struct {
+		topic    Topic
+		expected Topic
+	}{
+		{topic: "zigbee2mqtt/kitchen", expected: "home/kitchen"},
+		{topic: "zigbee2mqtt", expected: "home"},
+		{topic: "zigbee2mqttbridge/state", expected: "zigbee2mqttbridge/state"},
+	}
+	interceptor := RenameInterceptor("zigbee2mqtt", "home")
+	for _, test := range tests {
+		var renamed Topic
+		interceptor.Intercept(TopicPayload{Topic: test.topic}, func(tp TopicPayload) {
+			renamed = tp.Topic
+		})
+		if renamed != test.expected {
+			t.Errorf("renaming %s: expected %s, got %s", test.topic, test.expected, renamed)
+		}
+	}
+}
+
+func TestRenameInterceptorStrip(t *testing.T) {
+	var renamed Topic
+	RenameInterceptor("zigbee2mqtt/", "").Intercept(TopicPayload{Topic: "zigbee2mqtt/kitchen"}, func(tp TopicPayload) {
+		renamed = tp.Topic
+	})
+	if renamed != "kitchen" {
+		t.Errorf("expected kitchen, got %s", renamed)
+	}
+}
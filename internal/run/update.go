@@ -3,23 +3,29 @@ package run
 import (
 	"context"
 	"errors"
+	"sort"
 
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 )
 
-type UpdaterPlugin struct{}
+type UpdaterPlugin struct {
+	errors ErrorConfig
+}
 
-func NewUpdaterPlugin() *UpdaterPlugin {
-	return &UpdaterPlugin{}
+func NewUpdaterPlugin(errors ErrorConfig) *UpdaterPlugin {
+	return &UpdaterPlugin{
+		errors: errors,
+	}
 }
 
 func (u *UpdaterPlugin) Start(ctx context.Context, g *errgroup.Group, registry Registry, source Broker, view Broker, sink Broker) {
+	subscriptions := subscribeTopics(registry.TopicToModels, source)
 	g.Go(func() error {
 		updaterCtx, updaterCancel := context.WithCancel(ctx)
 		defer updaterCancel()
 
-		err := runUpdater(updaterCtx, registry.TopicToModels, source, view, sink)
+		err := runUpdater(updaterCtx, u.errors, subscriptions, source, view, sink)
 		if err != nil && !errors.Is(err, context.Canceled) {
 			return err
 		}
@@ -27,81 +33,95 @@ func (u *UpdaterPlugin) Start(ctx context.Context, g *errgroup.Group, registry R
 	})
 }
 
+type topicSubscription struct {
+	filter      Topic
+	models      []Model
+	ch          <-chan TopicPayload
+	unsubscribe Unsubscribe
+}
+
+func subscribeTopics(topicToModels map[Topic][]Model, source Broker) []topicSubscription {
+	var subscriptions []topicSubscription
+	for filter, models := range topicToModels {
+		ch, unsubscribe := source.Subscribe(filter, WithPolicy(PolicyUnbounded))
+		subscriptions = append(subscriptions, topicSubscription{
+			filter:      filter,
+			models:      models,
+			ch:          ch,
+			unsubscribe: unsubscribe,
+		})
+	}
+	return subscriptions
+}
+
 func runUpdater(
 	ctx context.Context,
-	topicToModels map[Topic][]Model,
+	errorConfig ErrorConfig,
+	subscriptions []topicSubscription,
 	source Broker,
 	view Broker,
 	sink Broker,
 ) error {
+	for _, subscription := range subscriptions {
+		defer subscription.unsubscribe()
+	}
+
 	g, gCtx := errgroup.WithContext(ctx)
 
-	for topic, models := range topicToModels {
-		topic := topic
-		models := models
+	mailboxes := make(map[Key]*mailbox[delivery])
+	modelFilters := make(map[Key][]Topic)
+	for _, subscription := range subscriptions {
+		for _, model := range subscription.models {
+			modelFilters[model.Key()] = append(modelFilters[model.Key()], subscription.filter)
+			if _, ok := mailboxes[model.Key()]; ok {
+				continue
+			}
+			mb := newMailbox[delivery]()
+			mailboxes[model.Key()] = mb
 
-		g.Go(func() error {
-			ch, unsubscribe := source.Subscribe(topic)
-			defer unsubscribe()
+			g.Go(func() error {
+				return runMailbox(gCtx, errorConfig, model, mb, source, view, sink)
+			})
+		}
+	}
+	for key, filters := range modelFilters {
+		sort.Strings(filters)
+		modelFilters[key] = filters
+	}
+	for _, subscription := range subscriptions {
+		for _, model := range subscription.models {
+			if described, ok := model.(DescribedModel); ok {
+				modelFilters[model.Key()] = described.Subscriptions()
+			}
+		}
+	}
 
+	for _, subscription := range subscriptions {
+		filter := subscription.filter
+		models := subscription.models
+		ch := subscription.ch
+
+		g.Go(func() error {
 			for {
 				select {
 				case <-gCtx.Done():
 					return gCtx.Err()
-				case payload, ok := <-ch:
+				case tp, ok := <-ch:
 					if !ok {
 						return nil
 					}
 
-					log.WithField("topic", topic).
-						WithField("payload", payload).
+					log.WithField("filter", filter).
+						WithField("topic", tp.Topic).
+						WithField("payload", tp.Payload).
 						Info("received message from topic")
 
-					var allViews []TopicPayload
-					var allCommands []TopicPayload
-
 					for _, model := range models {
-						commands, err := model.Update(gCtx, topic, payload)
-						if err != nil {
-							log.WithError(err).
-								WithField("topic", topic).
-								WithField("payload", payload).
-								Error("failed to handle message")
-							continue
-						}
-						for topic, payload := range commands {
-							allCommands = append(allCommands, TopicPayload{
-								Topic:   topic,
-								Payload: payload,
-							})
-						}
-
-						view, err := model.View(gCtx)
-						if err != nil {
-							log.WithError(err).
-								WithField("topic", topic).
-								WithField("payload", payload).
-								Error("failed to render view")
+						delivering, _, _ := MatchFirst(modelFilters[model.Key()], tp.Topic)
+						if delivering != filter {
 							continue
 						}
-						allViews = append(allViews, TopicPayload{
-							Topic:   model.Key(),
-							Payload: view,
-						})
-					}
-
-					for _, v := range allViews {
-						log.WithField("key", v.Topic).
-							WithField("view", v.Payload).
-							Info("publishing view to key")
-						view.Publish(v.Topic, v.Payload)
-					}
-
-					for _, cmd := range allCommands {
-						log.WithField("topic", cmd.Topic).
-							WithField("payload", cmd.Payload).
-							Info("publishing command to topic")
-						sink.Publish(cmd.Topic, cmd.Payload)
+						mailboxes[model.Key()].post(delivery{filter: filter, tp: tp})
 					}
 				}
 			}
@@ -110,3 +130,65 @@ func runUpdater(
 
 	return g.Wait()
 }
+
+// delivery is a message for a model together with the one subscription
+// filter that delivers it. A model with overlapping subscriptions receives
+// each message once, through the first matching filter in the order of its
+// subscriptions, or in sorted order for models that do not list them.
+type delivery struct {
+	filter Topic
+	tp     TopicPayload
+}
+
+func runMailbox(
+	ctx context.Context,
+	errorConfig ErrorConfig,
+	model Model,
+	mb *mailbox[delivery],
+	source Broker,
+	view Broker,
+	sink Broker,
+) error {
+	for {
+		d, err := mb.receive(ctx)
+		if err != nil {
+			return err
+		}
+		tp := d.tp
+		topic := tp.Topic
+		payload := tp.Payload
+
+		commands, err := model.Update(ctx, d.filter, topic, payload)
+		if err != nil {
+			log.WithError(err).
+				WithField("topic", topic).
+				WithField("payload", payload).
+				Error("failed to handle message")
+			ReportError(errorConfig, sink, model.Key(), "update", tp, err)
+			continue
+		}
+
+		v, err := model.View(ctx)
+		if err != nil {
+			log.WithError(err).
+				WithField("topic", topic).
+				WithField("payload", payload).
+				Error("failed to render view")
+			ReportError(errorConfig, sink, model.Key(), "view", tp, err)
+		} else {
+			log.WithField("key", model.Key()).
+				WithField("view", v).
+				Info("publishing view to key")
+			view.Publish(model.Key(), v)
+		}
+
+		for _, command := range commands {
+			log.WithField("topic", command.Topic).
+				WithField("payload", command.Payload).
+				Info("publishing command to topic")
+			command.Origin = tp.Origin
+			command.Hops = tp.Hops
+			sink.PublishMessage(command)
+		}
+	}
+}
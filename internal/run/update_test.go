@@ -0,0 +1,93 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const stressConfig = `
+local counter(prefix) = {
+  subscriptions: [prefix + '/a', prefix + '/+'],
+  init: { count: 0 },
+  local increment(model, msg) = { model: { count: model.count + 1 } },
+  update: {
+    [prefix + '/a']: increment,
+    [prefix + '/+']: increment,
+  },
+};
+
+{
+  first: { app: counter('first') },
+  second: { app: counter('second') },
+}
+`
+
+// TestUpdaterLosesNoUpdates publishes to two overlapping subscriptions of
+// each app from several goroutines at once. Every message must be counted
+// exactly once.
+func TestUpdaterLosesNoUpdates(t *testing.T) {
+	const publishers = 4
+	const perPublisher = 250
+
+	config := filepath.Join(t.TempDir(), "config.jsonnet")
+	err := os.WriteFile(config, []byte(stressConfig), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := NewAppRegistration(AppConfig{Config: config}, NoopStateStore{}, time.Now).Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewBroker("source", BrokerConfig{})
+	view := NewBroker("view", BrokerConfig{})
+	sink := NewBroker("sink", BrokerConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	g, gCtx := errgroup.WithContext(ctx)
+	NewUpdaterPlugin(ErrorConfig{}).Start(gCtx, g, registry, source, view, sink)
+
+	var wg sync.WaitGroup
+	for i := range publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range perPublisher {
+				level := []string{"a", "b"}[(i+j)%2]
+				source.Publish("first/"+level, "{}")
+				source.Publish("second/"+level, "{}")
+			}
+		}()
+	}
+	wg.Wait()
+
+	expected := map[string]any{"count": float64(publishers * perPublisher)}
+	for _, key := range []Key{"first", "second"} {
+		model := registry.KeyToModel[key].(DescribedModel)
+		var state any
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			state, err = model.State(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(state) == fmt.Sprint(expected) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if fmt.Sprint(state) != fmt.Sprint(expected) {
+			t.Errorf("expected %s to count %v, got %v", key, expected, state)
+		}
+	}
+
+	cancel()
+	_ = g.Wait()
+}
@@ -0,0 +1,77 @@
+package run
+
+import (
+	"github.com/google/go-jsonnet"
+)
+
+// vmPool hands out long-lived VMs so that imported files, including the app
+// config, are parsed and evaluated once per VM instead of once per message.
+type vmPool struct {
+	vms   chan *jsonnet.VM
+	newVM func() *jsonnet.VM
+}
+
+func newVMPool(size int, newVM func() *jsonnet.VM) *vmPool {
+	vms := make(chan *jsonnet.VM, size)
+	for i := 0; i < size; i++ {
+		vms <- nil
+	}
+	return &vmPool{
+		vms:   vms,
+		newVM: newVM,
+	}
+}
+
+func (p *vmPool) get() *jsonnet.VM {
+	vm := <-p.vms
+	if vm == nil {
+		vm = p.newVM()
+	}
+	vm.TLAReset()
+	return vm
+}
+
+func (p *vmPool) put(vm *jsonnet.VM) {
+	p.vms <- vm
+}
+
+// cachingImporter returns the same Contents for repeated imports, which a
+// long-lived VM requires of its importer.
+type cachingImporter struct {
+	importer jsonnet.Importer
+	cache    map[[2]string]importResult
+}
+
+type importResult struct {
+	contents jsonnet.Contents
+	foundAt  string
+}
+
+func newCachingImporter(importer jsonnet.Importer) *cachingImporter {
+	return &cachingImporter{
+		importer: importer,
+		cache:    make(map[[2]string]importResult),
+	}
+}
+
+func (c *cachingImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	key := [2]string{importedFrom, importedPath}
+	if res, ok := c.cache[key]; ok {
+		return res.contents, res.foundAt, nil
+	}
+	contents, foundAt, err := c.importer.Import(importedFrom, importedPath)
+	if err != nil {
+		return jsonnet.Contents{}, "", err
+	}
+	for _, res := range c.cache {
+		if res.foundAt == foundAt {
+			contents = res.contents
+			break
+		}
+	}
+	c.cache[key] = importResult{
+		contents: contents,
+		foundAt:  foundAt,
+	}
+	return contents, foundAt, nil
+}
@@ -0,0 +1,71 @@
+package run
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func benchmarkAppLib(b *testing.B) AppLib {
+	b.Helper()
+	config, err := filepath.Abs("../../examples/count/home.jsonnet")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return AppLib{
+		config: config,
+		clock:  FixedClock(time.Unix(0, 0)),
+	}
+}
+
+// BenchmarkAppLibUpdate compares pooled VMs, which keep the parsed config
+// between messages, against a fresh VM that imports everything per message.
+func BenchmarkAppLibUpdate(b *testing.B) {
+	model := map[string]any{"value": 0}
+
+	b.Run("pooled", func(b *testing.B) {
+		appLib := benchmarkAppLib(b).withVMPool()
+		for b.Loop() {
+			_, err := appLib.update("count", "yokai/test/input-a", "yokai/test/input-a", nil, `{"add":1}`, model, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("fresh", func(b *testing.B) {
+		appLib := benchmarkAppLib(b)
+		for b.Loop() {
+			appLib.vms = newVMPool(1, appLib.vm)
+			_, err := appLib.update("count", "yokai/test/input-a", "yokai/test/input-a", nil, `{"add":1}`, model, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAppLibView(b *testing.B) {
+	model := map[string]any{"value": 0}
+
+	b.Run("pooled", func(b *testing.B) {
+		appLib := benchmarkAppLib(b).withVMPool()
+		for b.Loop() {
+			_, err := appLib.view("count", model, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("fresh", func(b *testing.B) {
+		appLib := benchmarkAppLib(b)
+		for b.Loop() {
+			appLib.vms = newVMPool(1, appLib.vm)
+			_, err := appLib.view("count", model, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package serve
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marcbran/yokai/internal/plugins/http"
+	"github.com/marcbran/yokai/internal/plugins/loop"
+	"github.com/marcbran/yokai/internal/plugins/mqtt"
+	"github.com/marcbran/yokai/internal/run"
+	"github.com/spf13/viper"
+)
+
+// LoadConfig reads config.yaml from configPath and YOKAI_ environment
+// variables, and resolves relative paths against configPath.
+func LoadConfig(configPath string) (*Config, error) {
+	if configPath == "" {
+		if envPath := os.Getenv("YOKAI_CONFIG_PATH"); envPath != "" {
+			configPath = envPath
+		}
+	}
+
+	v := viper.New()
+
+	v.SetDefault("mqtt.enabled", false)
+	v.SetDefault("mqtt.client_id", "yokai")
+	v.SetDefault("mqtt.keep_alive", "2s")
+	v.SetDefault("mqtt.ping_timeout", "1s")
+	v.SetDefault("mqtt.publish_timeout", mqtt.DefaultPublishTimeout.String())
+	v.SetDefault("http.enabled", false)
+	v.SetDefault("http.scheme", "http")
+	v.SetDefault("http.hostname", "localhost")
+	v.SetDefault("http.port", 8000)
+	v.SetDefault("http.sse_ping_interval", http.DefaultSsePingInterval.String())
+	v.SetDefault("http.auth.enabled", false)
+	v.SetDefault("http.auth.session.cookie", http.DefaultSessionCookie)
+	v.SetDefault("http.auth.session.max_age", http.DefaultSessionMaxAge.String())
+	v.SetDefault("app.config", "config.jsonnet")
+	v.SetDefault("app.vendor", []string{})
+	v.SetDefault("state.enabled", false)
+	v.SetDefault("state.path", "state.json")
+	v.SetDefault("state.flush_interval", "5s")
+	v.SetDefault("kv.persist", true)
+	v.SetDefault("kv.path", "kv.json")
+	v.SetDefault("errors.topic", run.DefaultErrorTopic)
+	v.SetDefault("broker.buffer_size", run.DefaultBufferSize)
+	v.SetDefault("broker.policy", string(run.PolicyDropNewest))
+	v.SetDefault("broker.block_timeout", run.DefaultBlockTimeout.String())
+	v.SetDefault("broker.stats_interval", run.DefaultStatsInterval.String())
+	v.SetDefault("exec.enabled", false)
+	v.SetDefault("commands.http.allowed_hosts", []string{})
+	v.SetDefault("commands.http.timeout", run.DefaultHttpTimeout.String())
+	v.SetDefault("commands.http.max_body_size", run.DefaultHttpMaxBodySize)
+	v.SetDefault("commands.exec.timeout", run.DefaultExecTimeout.String())
+	v.SetDefault("commands.exec.max_output_size", run.DefaultExecMaxOutputSize)
+	v.SetDefault("loop.enabled", true)
+	v.SetDefault("loop.prefixes", []string{})
+	v.SetDefault("loop.max_hops", loop.DefaultMaxHops)
+
+	v.SetConfigName("config")
+	v.SetConfigType("yaml")
+	v.AddConfigPath(configPath)
+	v.AddConfigPath(".")
+	_ = v.ReadInConfig()
+
+	v.SetEnvPrefix("YOKAI")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	_ = v.BindEnv("mqtt.broker")
+	_ = v.BindEnv("mqtt.client_id")
+	_ = v.BindEnv("mqtt.keep_alive")
+	_ = v.BindEnv("mqtt.ping_timeout")
+	_ = v.BindEnv("mqtt.publish_timeout")
+	_ = v.BindEnv("mqtt.suppress_echo")
+	_ = v.BindEnv("http.scheme")
+	_ = v.BindEnv("http.hostname")
+	_ = v.BindEnv("http.port")
+	_ = v.BindEnv("http.sse_ping_interval")
+	_ = v.BindEnv("http.auth.enabled")
+	_ = v.BindEnv("http.auth.session.secret")
+	_ = v.BindEnv("http.auth.session.cookie")
+	_ = v.BindEnv("http.auth.session.max_age")
+	_ = v.BindEnv("http.auth.session.secure")
+	_ = v.BindEnv("http.credentials.token")
+	_ = v.BindEnv("http.credentials.username")
+	_ = v.BindEnv("http.credentials.password")
+	_ = v.BindEnv("app.config")
+	_ = v.BindEnv("app.vendor")
+	_ = v.BindEnv("state.enabled")
+	_ = v.BindEnv("state.path")
+	_ = v.BindEnv("state.flush_interval")
+	_ = v.BindEnv("kv.persist")
+	_ = v.BindEnv("kv.path")
+	_ = v.BindEnv("errors.topic")
+	_ = v.BindEnv("broker.buffer_size")
+	_ = v.BindEnv("broker.policy")
+	_ = v.BindEnv("broker.block_timeout")
+	_ = v.BindEnv("broker.stats_interval")
+	_ = v.BindEnv("exec.enabled")
+	_ = v.BindEnv("commands.http.allowed_hosts")
+	_ = v.BindEnv("commands.http.timeout")
+	_ = v.BindEnv("commands.http.max_body_size")
+	_ = v.BindEnv("commands.exec.timeout")
+	_ = v.BindEnv("commands.exec.max_output_size")
+	_ = v.BindEnv("loop.enabled")
+	_ = v.BindEnv("loop.prefixes")
+	_ = v.BindEnv("loop.max_hops")
+
+	// Suppressed echoes only reach the apps through the loop plugin, so echo
+	// suppression is on by default only while the loop is.
+	v.SetDefault("mqtt.suppress_echo", v.GetBool("loop.enabled"))
+
+	var cfg Config
+	err := v.Unmarshal(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Mqtt.Enabled && cfg.Mqtt.SuppressEcho && !cfg.Loop.Enabled {
+		return nil, errors.New("mqtt.suppress_echo requires loop.enabled, apps would never receive the messages they publish")
+	}
+
+	if cfg.App.Config != "" && !filepath.IsAbs(cfg.App.Config) {
+		cfg.App.Config = filepath.Join(configPath, cfg.App.Config)
+	}
+	if cfg.State.Path != "" && !filepath.IsAbs(cfg.State.Path) {
+		cfg.State.Path = filepath.Join(configPath, cfg.State.Path)
+	}
+	if cfg.KV.Path != "" && !filepath.IsAbs(cfg.KV.Path) {
+		cfg.KV.Path = filepath.Join(configPath, cfg.KV.Path)
+	}
+	for i, process := range cfg.Exec.Processes {
+		if process.Dir == "" {
+			cfg.Exec.Processes[i].Dir = configPath
+		} else if !filepath.IsAbs(process.Dir) {
+			cfg.Exec.Processes[i].Dir = filepath.Join(configPath, process.Dir)
+		}
+	}
+	for name, allowed := range cfg.Commands.Exec.Allowed {
+		if allowed.Dir == "" {
+			allowed.Dir = configPath
+		} else if !filepath.IsAbs(allowed.Dir) {
+			allowed.Dir = filepath.Join(configPath, allowed.Dir)
+		}
+		cfg.Commands.Exec.Allowed[name] = allowed
+	}
+	if cfg.App.Vendor != nil {
+		for i, vendorPath := range cfg.App.Vendor {
+			if !filepath.IsAbs(vendorPath) {
+				cfg.App.Vendor[i] = filepath.Join(configPath, vendorPath)
+			}
+		}
+	}
+
+	return &cfg, nil
+}
@@ -5,9 +5,12 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/marcbran/yokai/internal/plugins/exec"
 	"github.com/marcbran/yokai/internal/plugins/http"
+	"github.com/marcbran/yokai/internal/plugins/loop"
 	"github.com/marcbran/yokai/internal/plugins/mqtt"
 	"github.com/marcbran/yokai/internal/run"
 	log "github.com/sirupsen/logrus"
@@ -16,32 +19,61 @@ import (
 )
 
 type Config struct {
-	Mqtt mqtt.Config   `mapstructure:"mqtt"`
-	Http http.Config   `mapstructure:"http"`
-	App  run.AppConfig `mapstructure:"app"`
+	Mqtt     mqtt.Config        `mapstructure:"mqtt"`
+	Http     http.Config        `mapstructure:"http"`
+	App      run.AppConfig      `mapstructure:"app"`
+	State    run.StateConfig    `mapstructure:"state"`
+	Errors   run.ErrorConfig    `mapstructure:"errors"`
+	Loop     loop.Config        `mapstructure:"loop"`
+	Broker   run.BrokerConfig   `mapstructure:"broker"`
+	Exec     exec.Config        `mapstructure:"exec"`
+	Commands run.CommandsConfig `mapstructure:"commands"`
+	KV       run.KVConfig       `mapstructure:"kv"`
 }
 
-func Serve(ctx context.Context, config *Config) error {
+// Serve runs the apps of config together with the built-in commands and
+// plugins. Embedders can add their own registrations and plugins.
+func Serve(ctx context.Context, config *Config, extraRegistrations []run.Registration, extraPlugins []run.Plugin) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	registration := run.NewCompoundRegistration(
-		[]run.Registration{
-			run.NewAppRegistration(config.App),
-			run.CommandRegistration{},
-		},
-	)
+	var store run.StateStore = run.NoopStateStore{}
+	clock := run.Clock(time.Now)
+	errorConfig := config.Errors.WithClock(clock)
+	scheduler := run.NewScheduler(clock)
+	kv := run.NewKVStore(config.KV)
 	plugins := []run.Plugin{
-		run.NewUpdaterPlugin(),
+		run.NewUpdaterPlugin(errorConfig),
+		run.NewCommanderPlugin(config.Loop.MaxHops, errorConfig),
+		scheduler,
+		loop.NewPlugin(config.Loop, errorConfig),
 		mqtt.NewPlugin(config.Mqtt),
 		http.NewPlugin(config.Http),
+		exec.NewPlugin(config.Exec),
 	}
+	if config.State.Enabled {
+		fileStore := run.NewFileStateStore(config.State)
+		store = fileStore
+		plugins = append(plugins, fileStore)
+	}
+	plugins = append(plugins, extraPlugins...)
+
+	registration := run.NewCompoundRegistration(
+		append([]run.Registration{
+			run.NewAppRegistration(config.App, store, clock),
+			run.NewCommandRegistration(config.Commands, scheduler, kv),
+		}, extraRegistrations...),
+	)
 
 	configPath := config.App.Config
 	configDir := filepath.Dir(configPath)
-	return reloadOnFileChanges(ctx, configDir, func(ctx context.Context) error {
-		return run.Run(ctx, registration, plugins)
+	err := reloadOnFileChanges(ctx, configDir, func(ctx context.Context) error {
+		return run.Run(ctx, config.Broker, registration, plugins)
 	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
 }
 
 func reloadOnFileChanges(ctx context.Context, dir string, body func(ctx context.Context) error) error {
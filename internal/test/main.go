@@ -15,6 +15,7 @@ import (
 	"github.com/google/go-jsonnet"
 	"github.com/marcbran/jsonnet-kit/pkg/jsonnext"
 	"github.com/marcbran/yokai/internal/plugins/inout"
+	"github.com/marcbran/yokai/internal/plugins/loop"
 	"github.com/marcbran/yokai/internal/run"
 	"golang.org/x/sync/errgroup"
 )
@@ -25,6 +26,7 @@ type Config struct {
 
 type Case struct {
 	Name    string
+	Now     string             `json:"now"`
 	Inputs  []run.TopicPayload `json:"inputs"`
 	Outputs []run.TopicPayload `json:"outputs"`
 }
@@ -115,16 +117,30 @@ func RunFile(ctx context.Context, filename string) (*Run, error) {
 }
 
 func RunTestCase(ctx context.Context, config *Config, testCase Case) (*Run, error) {
+	clock := run.Clock(time.Now)
+	if testCase.Now != "" {
+		now, err := time.Parse(time.RFC3339, testCase.Now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid now in test case %s: %w", testCase.Name, err)
+		}
+		clock = run.FixedClock(now)
+	}
+
+	errorConfig := run.ErrorConfig{Topic: run.DefaultErrorTopic}.WithClock(clock)
+	scheduler := run.NewScheduler(clock)
 	registration := run.NewCompoundRegistration(
 		[]run.Registration{
-			run.NewAppRegistration(config.App),
-			run.CommandRegistration{},
+			run.NewAppRegistration(config.App, run.NoopStateStore{}, clock),
+			run.NewCommandRegistration(run.CommandsConfig{}, scheduler, run.NewKVStore(run.KVConfig{})),
 		},
 	)
 
 	inoutPlugin := inout.NewPlugin(testCase.Inputs)
 	plugins := []run.Plugin{
-		run.NewUpdaterPlugin(),
+		run.NewUpdaterPlugin(errorConfig),
+		run.NewCommanderPlugin(run.DefaultMaxHops, errorConfig),
+		scheduler,
+		loop.NewPlugin(loop.Config{Enabled: true}, errorConfig),
 		inoutPlugin,
 	}
 
@@ -134,7 +150,7 @@ func RunTestCase(ctx context.Context, config *Config, testCase Case) (*Run, erro
 	g, gCtx := errgroup.WithContext(runCtx)
 
 	g.Go(func() error {
-		return run.Run(gCtx, registration, plugins)
+		return run.Run(gCtx, run.BrokerConfig{}, registration, plugins)
 	})
 
 	actualOutputs := inoutPlugin.Outputs()
@@ -143,7 +159,7 @@ func RunTestCase(ctx context.Context, config *Config, testCase Case) (*Run, erro
 	resultChan := make(chan bool, 1)
 
 	g.Go(func() error {
-		result := equalOutputs(expectedOutputs, actualOutputs)
+		result := equalOutputs(expectedOutputs, withoutErrors(gCtx, errorConfig, actualOutputs))
 		resultChan <- result
 		return nil
 	})
@@ -167,6 +183,32 @@ func RunTestCase(ctx context.Context, config *Config, testCase Case) (*Run, erro
 	}
 }
 
+// withoutErrors drops error reports from the outputs. Their payloads carry
+// generated origins and stack traces, so tests check them through apps that
+// subscribe to the error topic instead.
+func withoutErrors(ctx context.Context, errorConfig run.ErrorConfig, outputs <-chan run.TopicPayload) <-chan run.TopicPayload {
+	res := make(chan run.TopicPayload)
+	go func() {
+		defer close(res)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case output := <-outputs:
+				if strings.HasPrefix(output.Topic, errorConfig.Topic+"/") {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case res <- output:
+				}
+			}
+		}
+	}()
+	return res
+}
+
 func equalOutputs(expected []run.TopicPayload, actualOutputs <-chan run.TopicPayload) bool {
 	receivedOutputs := make([]run.TopicPayload, 0, len(expected))
 
@@ -187,6 +229,9 @@ func equalOutputs(expected []run.TopicPayload, actualOutputs <-chan run.TopicPay
 		if expectedOutput.Topic != actualOutput.Topic || expectedOutput.Payload != actualOutput.Payload {
 			return false
 		}
+		if expectedOutput.Qos != actualOutput.Qos || expectedOutput.Retain != actualOutput.Retain {
+			return false
+		}
 	}
 
 	return true
@@ -195,7 +240,10 @@ func equalOutputs(expected []run.TopicPayload, actualOutputs <-chan run.TopicPay
 //go:embed lib
 var lib embed.FS
 
-func loadTestCases(config string) ([]Case, error) {
+// newVM returns a VM for loading test cases, which may call the same native
+// functions as app code. Test cases are loaded before their clock is known,
+// so natives see the current time here.
+func newVM() *jsonnet.VM {
 	vm := jsonnet.MakeVM()
 	vm.Importer(jsonnext.CompoundImporter{
 		Importers: []jsonnet.Importer{
@@ -203,6 +251,14 @@ func loadTestCases(config string) ([]Case, error) {
 			&jsonnet.FileImporter{},
 		},
 	})
+	for _, f := range run.NativeFunctions(run.Clock(time.Now)) {
+		vm.NativeFunction(f)
+	}
+	return vm
+}
+
+func loadTestCases(config string) ([]Case, error) {
+	vm := newVM()
 	vm.TLACode("testConfig", fmt.Sprintf("import '%s'", config))
 	jsonStr, err := vm.EvaluateFile("./lib/load_test_cases.libsonnet")
 	if err != nil {
@@ -0,0 +1,149 @@
+// Package yokai embeds the yokai runtime into another Go program, so that
+// Go-native commands, models and plugins can run next to the jsonnet apps.
+package yokai
+
+import (
+	"context"
+
+	"github.com/marcbran/yokai/internal/run"
+	"github.com/marcbran/yokai/internal/serve"
+)
+
+type (
+	Topic        = run.Topic
+	Payload      = run.Payload
+	Key          = run.Key
+	TopicPayload = run.TopicPayload
+
+	Registration   = run.Registration
+	Registry       = run.Registry
+	Model          = run.Model
+	DescribedModel = run.DescribedModel
+	Command        = run.Command
+	Plugin         = run.Plugin
+
+	Broker          = run.Broker
+	Unsubscribe     = run.Unsubscribe
+	SubscribeOption = run.SubscribeOption
+	Policy          = run.Policy
+
+	Config = serve.Config
+)
+
+const (
+	PolicyDropNewest = run.PolicyDropNewest
+	PolicyDropOldest = run.PolicyDropOldest
+	PolicyBlock      = run.PolicyBlock
+	PolicyUnbounded  = run.PolicyUnbounded
+)
+
+func NewRegistry() Registry {
+	return run.NewRegistry()
+}
+
+func WithPolicy(policy Policy) SubscribeOption {
+	return run.WithPolicy(policy)
+}
+
+func WithBufferSize(size int) SubscribeOption {
+	return run.WithBufferSize(size)
+}
+
+func MatchTopic(filter Topic, topic Topic) ([]string, bool) {
+	return run.MatchTopic(filter, topic)
+}
+
+// LoadConfig reads the same config.yaml and YOKAI_ environment variables as
+// yokai serve.
+func LoadConfig(configPath string) (*Config, error) {
+	return serve.LoadConfig(configPath)
+}
+
+// CommandFunc adapts a function to the Command interface.
+type CommandFunc func(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error)
+
+func (f CommandFunc) Command(ctx context.Context, topic Topic, payload Payload) (map[Topic]Payload, error) {
+	return f(ctx, topic, payload)
+}
+
+// RegistrationFunc adapts a function to the Registration interface.
+type RegistrationFunc func() (Registry, error)
+
+func (f RegistrationFunc) Register() (Registry, error) {
+	return f()
+}
+
+type options struct {
+	configPath    string
+	config        *Config
+	registrations []Registration
+	plugins       []Plugin
+	commands      map[Topic][]Command
+}
+
+type Option func(o *options)
+
+// WithConfigPath loads the config from the given directory. It is ignored
+// when WithConfig is given.
+func WithConfigPath(configPath string) Option {
+	return func(o *options) {
+		o.configPath = configPath
+	}
+}
+
+func WithConfig(config *Config) Option {
+	return func(o *options) {
+		o.config = config
+	}
+}
+
+func WithRegistration(registration Registration) Option {
+	return func(o *options) {
+		o.registrations = append(o.registrations, registration)
+	}
+}
+
+func WithPlugin(plugin Plugin) Option {
+	return func(o *options) {
+		o.plugins = append(o.plugins, plugin)
+	}
+}
+
+// WithCommand runs command for every app output on topic.
+func WithCommand(topic Topic, command Command) Option {
+	return func(o *options) {
+		if o.commands == nil {
+			o.commands = make(map[Topic][]Command)
+		}
+		o.commands[topic] = append(o.commands[topic], command)
+	}
+}
+
+// Serve runs the apps, built-in commands and plugins like yokai serve does,
+// together with the registrations, commands and plugins given as options.
+// It blocks until ctx is cancelled.
+func Serve(ctx context.Context, opts ...Option) error {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	config := o.config
+	if config == nil {
+		var err error
+		config, err = LoadConfig(o.configPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	registrations := o.registrations
+	if len(o.commands) > 0 {
+		commands := o.commands
+		registrations = append(registrations, RegistrationFunc(func() (Registry, error) {
+			return Registry{TopicToCommands: commands}, nil
+		}))
+	}
+
+	return serve.Serve(ctx, config, registrations, o.plugins)
+}